@@ -0,0 +1,236 @@
+/*
+Package cli is the command line of the application.
+
+Every command reads the configuration like the server (defaults, YAML file, APP_* environment variables and the
+flags named as the keys, see utils.Loader), the commands working on the data open the database of the
+configuration:
+
+	serve                                  start the server (the default command)
+	migrate up | down [steps] | status     apply, roll back or list the migrations
+	user create <username>                 create a user
+	user disable <username>                disable a user (--enable enables it again)
+	user reset-password <username>         replace the password of a user and unlock it
+	token issue --user <username>          print an access token of a user
+	config validate                        check the configuration
+
+The exit code is 0 on success, 1 if the command failed and 2 if it is used in a wrong way.
+*/
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/server"
+	"github.com/mas2020-golang/rest-api/utils"
+	"io"
+	"os"
+	"strings"
+)
+
+// exit codes
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+// usage is printed by help and for an unknown command
+const usage = `usage: rest-api <command> [flags] [arguments]
+
+commands:
+  serve                                  start the server (the default command)
+  migrate up | down [steps] | status     apply, roll back or list the migrations
+  user create <username>                 create a user
+  user disable <username>                disable a user (--enable enables it again)
+  user reset-password <username>         replace the password of a user and unlock it
+  token issue --user <username>          print an access token of a user
+  config validate                        check the configuration
+
+every command accepts --config and the keys of the configuration as flags (e.g. --database.host=db), use
+'rest-api <command> -h' for the flags of a command
+`
+
+// usageError is a command used in a wrong way
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// usagef returns a usageError with the formatted message
+func usagef(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// CLI runs the commands
+type CLI struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Stderr receives the logs and the errors
+	Stderr io.Writer
+	// Open returns the application of the commands working on the data, with the configuration of loader and the
+	// repositories set. If nil the application is connected to the database of the configuration.
+	Open func(loader *utils.Loader) *server.App
+}
+
+// New returns the command line reading and writing the standard streams
+func New() *CLI {
+	return &CLI{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Run executes the command of args (the arguments without the program name) and returns the exit code
+func (c *CLI) Run(args []string) int {
+	err := c.run(args)
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(c.Stderr, "%s\n", err.Error())
+		return ExitUsage
+	}
+	fmt.Fprintf(c.Stderr, "error: %s\n", err.Error())
+	return ExitFailure
+}
+
+// run dispatches args to the command
+func (c *CLI) run(args []string) error {
+	// without a command the server is started, as before the subcommands existed
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return c.serve(args)
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "serve":
+		return c.serve(args)
+	case "migrate":
+		return c.migrate(args)
+	case "user":
+		return c.user(args)
+	case "token":
+		return c.token(args)
+	case "config":
+		return c.config(args)
+	case "help":
+		fmt.Fprint(c.Stdout, usage)
+		return nil
+	}
+	fmt.Fprint(c.Stderr, usage)
+	return usagef("unknown command '%s'", name)
+}
+
+// subcommand returns the name of the subcommand of a command (e.g. create of user create) and its arguments
+func subcommand(command string, args []string, names ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, usagef("usage: %s %s", command, strings.Join(names, " | "))
+	}
+	for _, name := range names {
+		if args[0] == name {
+			return name, args[1:], nil
+		}
+	}
+	return "", nil, usagef("unknown %s command '%s', use %s", command, args[0], strings.Join(names, ", "))
+}
+
+// flags are the flags of a command, with the flags of the configuration
+type flags struct {
+	*flag.FlagSet
+	// loader has the values of the flags of the configuration once parsed
+	loader    *utils.Loader
+	arguments string
+	stderr    io.Writer
+}
+
+// newFlags returns the flags of the command name having the positional arguments described by arguments
+func (c *CLI) newFlags(name, arguments string) *flags {
+	f := &flags{flag.NewFlagSet(name, flag.ContinueOnError), utils.NewLoader(), arguments, c.Stderr}
+	// the errors are returned by parse, the usage is printed only when asked
+	f.SetOutput(io.Discard)
+	f.Usage = func() {}
+	f.loader.RegisterFlags(f.FlagSet)
+	return f
+}
+
+// parse parses the flags of args, also the ones after the positional arguments, and returns the positional
+// arguments
+func (f *flags) parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := f.Parse(args)
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(f.stderr, "usage: rest-api %s [flags] %s\n\nflags:\n", f.Name(), f.arguments)
+			f.SetOutput(f.stderr)
+			f.PrintDefaults()
+			return nil, err
+		}
+		if err != nil {
+			return nil, usagef("%s, see 'rest-api %s -h'", err.Error(), f.Name())
+		}
+		if args = f.Args(); len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// open returns the application of the commands working on the data, the caller closes it
+func (c *CLI) open(loader *utils.Loader) *server.App {
+	if c.Open != nil {
+		return c.Open(loader)
+	}
+	a := &server.App{Loader: loader, LogOutput: c.Stderr}
+	a.Setup()
+	a.Connect()
+	a.UseDatabase()
+	return a
+}
+
+// close releases the resources of the application of a command
+func (c *CLI) close(a *server.App) {
+	if err := a.Close(context.Background()); err != nil {
+		fmt.Fprintf(c.Stderr, "unable to close the application: %s\n", err.Error())
+	}
+}
+
+// serve starts the server until it receives SIGINT or SIGTERM
+func (c *CLI) serve(args []string) error {
+	f := c.newFlags("serve", "")
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("serve has no arguments, got '%s'", strings.Join(positional, " "))
+	}
+	a := server.App{Loader: f.loader}
+	a.Initialize()
+	return a.Run("")
+}
+
+// config executes config validate: the configuration is loaded and the values not valid are reported
+func (c *CLI) config(args []string) error {
+	_, args, err := subcommand("config", args, "validate")
+	if err != nil {
+		return err
+	}
+	f := c.newFlags("config validate", "")
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usagef("config validate has no arguments, got '%s'", strings.Join(positional, " "))
+	}
+	if _, err = f.loader.Load(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "configuration %s is valid\n", f.loader.Path())
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/migrations"
+	"strconv"
+)
+
+// migrate executes the migrate commands: migrate up | down [steps] | status
+func (c *CLI) migrate(args []string) error {
+	name, args, err := subcommand("migrate", args, "up", "down", "status")
+	if err != nil {
+		return err
+	}
+	f := c.newFlags("migrate "+name, map[string]string{"down": "[steps]"}[name])
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	steps := 1
+	switch {
+	case name == "down" && len(positional) == 1:
+		if steps, err = strconv.Atoi(positional[0]); err != nil || steps < 1 {
+			return usagef("steps must be a positive number, got '%s'", positional[0])
+		}
+	case len(positional) > 0:
+		return usagef("too many arguments for migrate %s", name)
+	}
+
+	a := c.open(f.loader)
+	defer c.close(a)
+	if a.DBPool == nil {
+		return fmt.Errorf("the migrations need the database")
+	}
+	m, err := migrations.New(a.DBPool)
+	if err != nil {
+		return fmt.Errorf("unable to load the migrations: %w", err)
+	}
+	ctx := context.Background()
+	switch name {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate up failed: %w", err)
+		}
+		fmt.Fprintf(c.Stdout, "%d migrations applied\n", applied)
+	case "down":
+		rolledBack, err := m.Down(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("migrate down failed: %w", err)
+		}
+		fmt.Fprintf(c.Stdout, "%d migrations rolled back\n", rolledBack)
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate status failed: %w", err)
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied on " + s.AppliedOn.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(c.Stdout, "%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/handlers"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/utils"
+	"time"
+)
+
+// token executes token issue --user <username> [--ttl <duration>]: the access token of the user is printed
+func (c *CLI) token(args []string) error {
+	_, args, err := subcommand("token", args, "issue")
+	if err != nil {
+		return err
+	}
+	f := c.newFlags("token issue", "")
+	var (
+		username string
+		ttl      time.Duration
+	)
+	f.StringVar(&username, "user", "", "username of the owner of the token (required)")
+	f.DurationVar(&ttl, "ttl", 0, "lifetime of the token (default token.access_ttl)")
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(positional) > 0:
+		return usagef("token issue has no arguments, got '%s'", positional[0])
+	case len(username) == 0:
+		return usagef("usage: rest-api token issue --user <username> [--ttl <duration>]")
+	case ttl < 0:
+		return usagef("the ttl must be positive")
+	}
+
+	a := c.open(f.loader)
+	defer c.close(a)
+	if ttl == 0 {
+		ttl = utils.Server.Token.AccessTTL
+	}
+	// a random key would sign tokens refused by the server
+	if len(utils.Server.Token.Keys) == 0 {
+		return fmt.Errorf("no signing keys configured in token.keys, the server would not accept the token")
+	}
+	keys, err := security.LoadKeySet(utils.Server.Token.Keys, utils.Server.Token.ActiveKey)
+	if err != nil {
+		return fmt.Errorf("unable to load the signing keys: %w", err)
+	}
+
+	user, err := a.Users.GetByUsername(context.Background(), username)
+	if err != nil {
+		return fmt.Errorf("user '%s': %w", username, err)
+	}
+	if user.Disabled {
+		return fmt.Errorf("user '%s' is disabled", username)
+	}
+	token, err := handlers.IssueAccessToken(keys, user, ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Stdout, token)
+	return nil
+}
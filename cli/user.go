@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/server"
+	"github.com/mas2020-golang/rest-api/utils"
+	"strings"
+)
+
+// user executes the user commands: user create | disable | reset-password <username>
+func (c *CLI) user(args []string) error {
+	name, args, err := subcommand("user", args, "create", "disable", "reset-password")
+	if err != nil {
+		return err
+	}
+	f := c.newFlags("user "+name, "<username>")
+	var (
+		in            models.UserInput
+		passwordStdin bool
+		enable        bool
+	)
+	switch name {
+	case "create":
+		f.StringVar(&in.UserType, "type", security.RoleReader, "user type: admin, editor or reader")
+		f.StringVar(&in.Email, "email", "", "email of the user")
+		f.StringVar(&in.Description, "description", "", "description of the user")
+		f.BoolVar(&in.Disabled, "disabled", false, "create the user disabled")
+		passwordFlags(f.FlagSet, &in.Password, &passwordStdin)
+	case "reset-password":
+		passwordFlags(f.FlagSet, &in.Password, &passwordStdin)
+	case "disable":
+		f.BoolVar(&enable, "enable", false, "enable the user instead")
+	}
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usagef("usage: rest-api user %s [flags] <username>", name)
+	}
+	in.Username = positional[0]
+	if name == "create" {
+		if err = validateUser(in.User()); err != nil {
+			return err
+		}
+	}
+	if passwordStdin {
+		if in.Password, err = c.readPassword(); err != nil {
+			return err
+		}
+	}
+
+	a := c.open(f.loader)
+	defer c.close(a)
+	ctx := context.Background()
+	switch name {
+	case "create":
+		return c.createUser(ctx, a, &in)
+	case "disable":
+		return c.disableUser(ctx, a, in.Username, !enable)
+	}
+	return c.resetPassword(ctx, a, in.Username, in.Password)
+}
+
+// passwordFlags adds the flags giving the password, without them a random password is generated
+func passwordFlags(fs *flag.FlagSet, password *string, stdin *bool) {
+	fs.StringVar(password, "password", "", "password of the user, a random one is generated and printed if not set")
+	fs.BoolVar(stdin, "password-stdin", false, "read the password from the first line of the standard input")
+}
+
+// readPassword reads the password from the first line of stdin
+func (c *CLI) readPassword() (string, error) {
+	line, err := bufio.NewReader(c.Stdin).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		if err != nil {
+			return "", fmt.Errorf("unable to read the password from the standard input: %w", err)
+		}
+		return "", usagef("the password read from the standard input is empty")
+	}
+	return line, nil
+}
+
+// password returns password, a random one is generated and printed if it is empty
+func (c *CLI) password(password string) (string, error) {
+	if len(password) > 0 {
+		return password, nil
+	}
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	password = base64.RawURLEncoding.EncodeToString(b)
+	fmt.Fprintf(c.Stdout, "generated password: %s\n", password)
+	return password, nil
+}
+
+// hash returns the hash of the password with the algorithm of the configuration
+func hash(password string) (string, error) {
+	hasher, err := security.NewPasswordHasher(utils.Server.Password.Algorithm, utils.Server.Password.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return hasher.Hash(password)
+}
+
+// validateUser checks the fields of a new user
+func validateUser(user *models.User) error {
+	err := user.Validate()
+	fields := problem.Fields(err)
+	if len(fields) == 0 {
+		return err
+	}
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Message
+	}
+	return usagef("the user is not valid: %s", strings.Join(msgs, "; "))
+}
+
+// createUser stores the user of in
+func (c *CLI) createUser(ctx context.Context, a *server.App, in *models.UserInput) error {
+	user := in.User()
+	password, err := c.password(in.Password)
+	if err != nil {
+		return err
+	}
+	if user.ApiKey, err = hash(password); err != nil {
+		return err
+	}
+	if err = a.Users.Add(ctx, user); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "user '%s' created with id %d\n", user.Username, user.ID)
+	return nil
+}
+
+// disableUser disables or enables the user, the logins and the refresh of the tokens of a disabled user are refused
+func (c *CLI) disableUser(ctx context.Context, a *server.App, username string, disabled bool) error {
+	user, err := a.Users.GetByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("user '%s': %w", username, err)
+	}
+	if err = a.Users.SetDisabled(ctx, user.ID, disabled); err != nil {
+		return err
+	}
+	state := "disabled"
+	if !disabled {
+		state = "enabled"
+	}
+	fmt.Fprintf(c.Stdout, "user '%s' %s\n", username, state)
+	return nil
+}
+
+// resetPassword replaces the password of the user and unlocks it
+func (c *CLI) resetPassword(ctx context.Context, a *server.App, username, password string) error {
+	user, err := a.Users.GetByUsername(ctx, username)
+	if err != nil {
+		return fmt.Errorf("user '%s': %w", username, err)
+	}
+	if password, err = c.password(password); err != nil {
+		return err
+	}
+	apiKey, err := hash(password)
+	if err != nil {
+		return err
+	}
+	if err = a.Users.SetApiKey(ctx, user.ID, apiKey); err != nil {
+		return err
+	}
+	// the failed logins with the old password don't count anymore
+	if err = a.Users.SetLockedUntil(ctx, user.ID, nil, true); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "password of the user '%s' reset\n", username)
+	return nil
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ApiKeys is the handler for the /api-keys resources: every user manages its own keys, the calls need an access
+// token (see RequireBearer)
+type ApiKeys struct {
+	repo  models.ApiKeyRepository
+	users models.UserRepository
+}
+
+// NewApiKeys returns the api keys handler reading and writing through the given repository, the caller of the
+// calls is read from users
+func NewApiKeys(repo models.ApiKeyRepository, users models.UserRepository) *ApiKeys {
+	return &ApiKeys{repo, users}
+}
+
+// apiKeyInput is the body of AddApiKey and RotateApiKey
+type apiKeyInput struct {
+	Name      string     `json:"name"`
+	ExpiresOn *time.Time `json:"expires-on"`
+}
+
+// apiKeyResponse is returned when a key is created or rotated, the only time the key is visible
+type apiKeyResponse struct {
+	*models.ApiKey
+	Key string `json:"key"`
+}
+
+// GetApiKeys returns the keys of the caller, only their prefix is shown
+func (k *ApiKeys) GetApiKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := k.caller(w, r)
+	if !ok {
+		return
+	}
+	keys, err := k.repo.GetAll(r.Context(), userID)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	json, err := keys.ToJSON()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Write(json)
+}
+
+// AddApiKey creates a new key for the caller, the name is mandatory and without expires-on the key never expires
+func (k *ApiKeys) AddApiKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := k.caller(w, r)
+	if !ok {
+		return
+	}
+	in, ok := readApiKey(w, r, true)
+	if !ok {
+		return
+	}
+	key := &models.ApiKey{UserID: userID, Name: in.Name, ExpiresOn: in.ExpiresOn}
+	secret, err := newSecret(key)
+	if err == nil {
+		err = k.repo.Add(r.Context(), key)
+	}
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	writeApiKey(w, r, http.StatusCreated, key, secret)
+}
+
+// RotateApiKey replaces the key with a new one, the old key stops working. Without expires-on in the body the new
+// key has the same lifetime of the old one.
+func (k *ApiKeys) RotateApiKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	userID, ok := k.caller(w, r)
+	if !ok {
+		return
+	}
+	in, ok := readApiKey(w, r, false)
+	if !ok {
+		return
+	}
+	key, err := k.repo.Get(r.Context(), userID, id)
+	if err != nil {
+		returnApiKeyError(w, r, err)
+		return
+	}
+	switch {
+	case in.ExpiresOn != nil:
+		key.ExpiresOn = in.ExpiresOn
+	case key.ExpiresOn != nil:
+		expiresOn := time.Now().Add(key.ExpiresOn.Sub(key.Created))
+		key.ExpiresOn = &expiresOn
+	}
+	secret, err := newSecret(key)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	if err = k.repo.Rotate(r.Context(), key); err != nil {
+		returnApiKeyError(w, r, err)
+		return
+	}
+	key.LastUsedOn = nil
+	writeApiKey(w, r, http.StatusOK, key, secret)
+}
+
+// RevokeApiKey revokes the key, it is kept in the list as revoked
+func (k *ApiKeys) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	userID, ok := k.caller(w, r)
+	if !ok {
+		return
+	}
+	if err := k.repo.Revoke(r.Context(), userID, id); err != nil {
+		returnApiKeyError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// caller returns the id of the user making the call. In case of error the response is already written and ok is
+// false.
+func (k *ApiKeys) caller(w http.ResponseWriter, r *http.Request) (id int, ok bool) {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	name, _ := claims["name"].(string)
+	user, err := k.users.GetByUsername(r.Context(), name)
+	if err == models.ErrUserNotFound {
+		problem.Write(w, r, problem.Unauthorized("the user of the token doesn't exist"))
+		return 0, false
+	}
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return 0, false
+	}
+	return user.ID, true
+}
+
+// newSecret generates a new api key setting prefix and hash of key, the key itself is returned
+func newSecret(key *models.ApiKey) (string, error) {
+	secret, prefix, hash, err := security.NewApiKey()
+	if err != nil {
+		return "", err
+	}
+	key.Prefix, key.Hash = prefix, hash
+	return secret, nil
+}
+
+// readApiKey decodes and validates the body, it can be empty only if the name is not mandatory. In case of error
+// the response is already written and ok is false.
+func readApiKey(w http.ResponseWriter, r *http.Request, nameRequired bool) (in apiKeyInput, ok bool) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&in); err != nil && (nameRequired || err != io.EOF) {
+		problem.Write(w, r, problem.InvalidJSON(err))
+		return in, false
+	}
+	if nameRequired && (len(in.Name) == 0 || len(in.Name) > 100) {
+		problem.Write(w, r, problem.BadRequest("name is mandatory (max 100 characters)"))
+		return in, false
+	}
+	if in.ExpiresOn != nil && !in.ExpiresOn.After(time.Now()) {
+		problem.Write(w, r, problem.BadRequest("expires-on must be in the future"))
+		return in, false
+	}
+	return in, true
+}
+
+// writeApiKey writes the key with its secret and the given status code
+func writeApiKey(w http.ResponseWriter, r *http.Request, code int, key *models.ApiKey, secret string) {
+	body, err := json.Marshal(apiKeyResponse{key, secret})
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// returnApiKeyError maps the repository errors to the response status code
+func returnApiKeyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrApiKeyNotFound:
+		problem.Write(w, r, problem.NotFound(err.Error()))
+	default:
+		problem.Write(w, r, problem.Internal(err))
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// MaxBulkRows is the max number of rows that can be imported with a single request
+const MaxBulkRows = 10000
+
+// import modes
+const (
+	// atomicMode stores the products only if all the rows are valid
+	atomicMode = "atomic"
+	// bestEffortMode stores the valid rows and skips the wrong ones
+	bestEffortMode = "best-effort"
+)
+
+// rowError is the error for a single row of the import, Fields are the errors of the fields not valid
+type rowError struct {
+	Row    int                  `json:"row"`
+	Error  string               `json:"error"`
+	Fields []problem.FieldError `json:"fields,omitempty"`
+}
+
+// importReport is returned to the caller at the end of the import
+type importReport struct {
+	Mode     string     `json:"mode"`
+	Received int        `json:"received"`
+	Inserted int        `json:"inserted"`
+	Errors   []rowError `json:"errors"`
+}
+
+// ImportProducts is the handler for the bulk import of the products from a CSV (text/csv) or NDJSON
+// (application/x-ndjson) body. Every row is validated; with mode=atomic (the default) nothing is stored if a row is
+// wrong, with mode=best-effort the wrong rows are skipped. The valid rows are stored in a single transaction and
+// the errors are reported row by row.
+func (p *Products) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if len(mode) == 0 {
+		mode = atomicMode
+	}
+	if mode != atomicMode && mode != bestEffortMode {
+		problem.Write(w, r, problem.BadRequest(fmt.Sprintf("mode must be %s or %s", atomicMode, bestEffortMode)))
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != models.CSVType && mediaType != models.NDJSONType {
+		problem.Write(w, r, problem.UnsupportedMediaType(models.ErrBulkMediaType.Error()))
+		return
+	}
+
+	report := importReport{Mode: mode, Errors: []rowError{}}
+	var products models.ProductsT
+	err := models.DecodeProducts(mediaType, r.Body, func(row int, prod *models.Product, err error) error {
+		report.Received++
+		if report.Received > MaxBulkRows {
+			return fmt.Errorf("too many rows, the max is %d", MaxBulkRows)
+		}
+		if err == nil {
+			err = prod.Validate()
+		}
+		if fields := problem.Fields(err); len(fields) > 0 {
+			report.Errors = append(report.Errors, rowError{row, "the row is not valid", fields})
+			return nil
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, rowError{row, err.Error(), nil})
+			return nil
+		}
+		products = append(products, prod)
+		return nil
+	})
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	status := http.StatusCreated
+	if len(report.Errors) > 0 && mode == atomicMode {
+		status = http.StatusUnprocessableEntity
+		products = nil
+	}
+	if len(products) > 0 {
+		if err = p.repo.AddAll(r.Context(), products); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		report.Inserted = len(products)
+	}
+	logging.FromContext(r.Context()).Infof("bulk import: %d rows received, %d inserted", report.Received,
+		report.Inserted)
+	body, err := json.Marshal(report)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// ExportProducts is the handler streaming all the products as CSV (format=csv) or NDJSON (format=ndjson, the
+// default). The products are written while they are read, so they are never all in memory.
+func (p *Products) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	var mediaType, ext string
+	switch r.URL.Query().Get("format") {
+	case "", "ndjson":
+		mediaType, ext = models.NDJSONType, "ndjson"
+	case "csv":
+		mediaType, ext = models.CSVType, "csv"
+	default:
+		problem.Write(w, r, problem.BadRequest("format must be csv or ndjson"))
+		return
+	}
+	includeDeleted, err := includeDeletedParam(r)
+	if err != nil {
+		problem.Write(w, r, problem.Forbidden(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, ext))
+	e, _ := models.NewProductEncoder(mediaType, w)
+	deadline := &writeDeadline{rc: http.NewResponseController(w), timeout: p.writeTimeout}
+	rows := 0
+	err = p.repo.Walk(r.Context(), includeDeleted, func(prod *models.Product) error {
+		rows++
+		deadline.extend()
+		return e.Encode(prod)
+	})
+	if err == nil {
+		err = e.Flush()
+	}
+	if err != nil && rows == 0 {
+		// nothing has been written yet, the caller can still get a proper error
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Disposition")
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	if err != nil {
+		// the status code has already been sent, the client sees a truncated body
+		logging.FromContext(r.Context()).Errorf("export interrupted after %d rows: %s", rows, err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Infof("export completed: %d rows", rows)
+}
+
+// writeDeadline pushes forward the write deadline of a streamed response
+type writeDeadline struct {
+	rc       *http.ResponseController
+	timeout  time.Duration
+	extended time.Time
+}
+
+// extend moves the write deadline to timeout from now, at most once every half timeout. Nothing is done without a
+// timeout or if the writer doesn't support the deadlines (e.g. httptest.ResponseRecorder).
+func (d *writeDeadline) extend() {
+	now := time.Now()
+	if d.timeout <= 0 || now.Sub(d.extended) < d.timeout/2 {
+		return
+	}
+	d.extended = now
+	d.rc.SetWriteDeadline(now.Add(d.timeout))
+}
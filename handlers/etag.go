@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/mas2020-golang/rest-api/models"
+	"net/http"
+	"strings"
+)
+
+// etag returns the strong ETag of the product built on its version
+func etag(prod *models.Product) string {
+	return fmt.Sprintf(`"%d"`, prod.Version)
+}
+
+// ifMatch returns true if the If-Match header is missing or it matches the product. Following RFC 7232 only strong
+// ETags can match.
+func ifMatch(r *http.Request, prod *models.Product) bool {
+	header := r.Header.Get("If-Match")
+	if len(header) == 0 {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag(prod) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch returns true if the If-None-Match header matches the product, in that case the caller already has the
+// current representation. Following RFC 7232 the weak comparison is used.
+func ifNoneMatch(r *http.Request, prod *models.Product) bool {
+	header := r.Header.Get("If-None-Match")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(prod) {
+			return true
+		}
+	}
+	return false
+}
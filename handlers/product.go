@@ -8,37 +8,65 @@ import (
 	"context"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/mas2020-golang/goutils/output"
+	"github.com/mas2020-golang/rest-api/logging"
 	"github.com/mas2020-golang/rest-api/models"
-	"github.com/mas2020-golang/rest-api/utils"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Products struct {
-	pool *pgxpool.Pool
+	repo models.ProductRepository
+	// writeTimeout is the write timeout of the server, pushed forward while the export is streamed
+	writeTimeout time.Duration
 }
 
-func NewProducts(pool *pgxpool.Pool) *Products {
-	return &Products{pool}
+// NewProducts returns the products handler reading and writing through the given repository
+func NewProducts(repo models.ProductRepository) *Products {
+	return &Products{repo: repo}
+}
+
+// SetWriteTimeout tells the write timeout of the server: the export extends it while the rows are written, so it
+// limits the time between the rows and not the whole export
+func (p *Products) SetWriteTimeout(timeout time.Duration) {
+	p.writeTimeout = timeout
 }
 
 func (p *Products) GetProducts(w http.ResponseWriter, r *http.Request) {
-	output.InfoLog("", "GET /products")
 	// get the claims
 	//claims, _ := r.Context().Value("claims").(jwt.MapClaims) // cast the interface{} to jwt.MapClaims
 	//p.l.Printf("claims models in the context are %#v", claims)
 
-	lp, err := models.Products.GetAll(p.pool)
+	var err error
+	q := models.ProductQuery{}
+	q.IncludeDeleted, err = includeDeletedParam(r)
+	if err != nil {
+		problem.Write(w, r, problem.Forbidden(err.Error()))
+		return
+	}
+	if err = filterParams(r, &q); err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	if err = pageParams(r, &q); err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	page, err := p.repo.GetAll(r.Context(), q)
 	if err != nil {
-		utils.ReturnError(&w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, problem.Internal(err))
 		return
 	}
+	setLinkHeader(w, r, q, page)
 	// return JSON to the caller
-	json, err := lp.ToJSON()
+	json, err := page.ToJSON()
 	if err != nil {
-		utils.ReturnError(&w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, problem.Internal(err))
 		return
 	}
 	w.Write(json)
@@ -49,88 +77,278 @@ func (p *Products) GetProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		utils.ReturnError(&w, "{id} not found in the path", http.StatusNotFound)
+		problem.Write(w, r, problem.NotFound("{id} not found in the path"))
 		return
 	}
-	output.InfoLog("", fmt.Sprintf("GET /products/%d", id))
-	prod, err := models.Products.Get(p.pool, id)
+	includeDeleted, err := includeDeletedParam(r)
 	if err != nil {
-		utils.ReturnError(&w, fmt.Sprintf("product not found (%s)", err.Error()), http.StatusNotFound)
+		problem.Write(w, r, problem.Forbidden(err.Error()))
+		return
+	}
+	prod, err := p.repo.Get(r.Context(), id, includeDeleted)
+	if err != nil {
+		switch err {
+		case models.RecordNotFound:
+			problem.Write(w, r, problem.NotFound("product not found"))
+		default:
+			problem.Write(w, r, problem.Internal(err))
+		}
+		return
+	}
+	w.Header().Set("ETag", etag(prod))
+	if ifNoneMatch(r, prod) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	json, err := prod.ToJSON()
 	if err != nil {
-		utils.ReturnError(&w, err.Error(), http.StatusNotFound)
+		problem.Write(w, r, problem.Internal(err))
 		return
 	}
 	w.Write(json)
 }
 
 func (p *Products) AddProduct(w http.ResponseWriter, r *http.Request) {
-	output.InfoLog("", "POST /products")
 	// take the product from the request context. The product has been inserted into the context from the middleware function
 	// call
 	prod, _ := r.Context().Value("prod").(*models.Product) // cast the interface{} to *models.Product
-	output.DebugLog("", fmt.Sprintf("product content in http body: %#v", prod))
-	err := models.Products.Add(p.pool, prod)
+	logging.FromContext(r.Context()).Debugf("product content in http body: %#v", prod)
+	err := p.repo.Add(r.Context(), prod)
 	if err != nil {
-		utils.ReturnError(&w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, problem.Internal(err))
 		return
 	}
+	w.Header().Set("ETag", etag(prod))
 	w.WriteHeader(http.StatusCreated)
 	jsonBody, err := prod.ToJSON()
 	if err != nil {
-		utils.ReturnError(&w, fmt.Sprintf("error to marshall json response, %s", err.Error()),
-			http.StatusInternalServerError)
+		problem.Write(w, r, problem.Internal(err))
 		return
 	}
 	w.Write(jsonBody)
 }
 
-// UpdateProduct is the handler for the update of a single product
+// UpdateProduct is the handler for the update of a single product. If the If-Match header is given the product is
+// updated only if it has not been changed in the meantime, otherwise 412 is returned.
 func (p *Products) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	// retrieve the id from the path
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "{id} not found in the path", http.StatusBadRequest)
+		problem.Write(w, r, problem.BadRequest("{id} not found in the path"))
 		return
 	}
-	output.InfoLog("", fmt.Sprintf("PUT /products/%d", id))
 	// take the product from the request context. The product has been inserted into the context from the middleware function
 	// call
 	prod, _ := r.Context().Value("prod").(*models.Product) // cast the interface{} to *models.Product
-	output.DebugLog("", fmt.Sprintf("product content in http body: %#v", prod))
+	logging.FromContext(r.Context()).Debugf("product content in http body: %#v", prod)
 	prod.ID = id
-	err = models.Products.Update(p.pool, prod)
+	if len(r.Header.Get("If-Match")) > 0 {
+		stored, err := p.repo.Get(r.Context(), id, false)
+		if err != nil {
+			switch err {
+			case models.RecordNotFound:
+				problem.Write(w, r, problem.NotFound(err.Error()))
+			default:
+				problem.Write(w, r, problem.Internal(err))
+			}
+			return
+		}
+		if !ifMatch(r, stored) {
+			problem.Write(w, r, problem.PreconditionFailed(models.ErrVersionMismatch.Error()))
+			return
+		}
+		// the stored version is checked again while updating
+		prod.Version = stored.Version
+	}
+	err = p.repo.Update(r.Context(), prod)
 	if err != nil {
 		// error check
 		switch err {
 		case models.RecordNotFound:
-			http.Error(w, err.Error(), http.StatusNotFound)
+			problem.Write(w, r, problem.NotFound(err.Error()))
+		case models.ErrVersionMismatch:
+			problem.Write(w, r, problem.PreconditionFailed(err.Error()))
+		default:
+			problem.Write(w, r, problem.Internal(err))
+		}
+		return
+	}
+	w.Header().Set("ETag", etag(prod))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PatchProduct is the handler for the partial update of a single product. The body is a JSON Merge Patch
+// (application/merge-patch+json) or a JSON Patch (application/json-patch+json) applied to the stored product, the
+// patched product is validated and only the changed columns are written. If-Match is honoured as in UpdateProduct.
+func (p *Products) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	// retrieve the id from the path
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest("{id} not found in the path"))
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != models.MergePatchType && mediaType != models.JSONPatchType {
+		problem.Write(w, r, problem.UnsupportedMediaType(models.ErrPatchMediaType.Error()))
+		return
+	}
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	stored, err := p.repo.Get(r.Context(), id, false)
+	if err != nil {
+		switch err {
+		case models.RecordNotFound:
+			problem.Write(w, r, problem.NotFound(err.Error()))
+		default:
+			problem.Write(w, r, problem.Internal(err))
+		}
+		return
+	}
+	if !ifMatch(r, stored) {
+		problem.Write(w, r, problem.PreconditionFailed(models.ErrVersionMismatch.Error()))
+		return
+	}
+	prod, columns, err := stored.ApplyPatch(mediaType, patch)
+	if err != nil {
+		switch err {
+		case models.ErrPatchTestFailed:
+			problem.Write(w, r, problem.Conflict(err.Error()))
+		default:
+			problem.Write(w, r, problem.BadRequest(err.Error()))
+		}
+		return
+	}
+	if err := prod.Validate(); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return
+	}
+	logging.FromContext(r.Context()).Debugf("patched columns: %v", columns)
+	if len(columns) > 0 {
+		if len(r.Header.Get("If-Match")) == 0 {
+			// without a precondition the changed columns are written whatever the stored version is
+			prod.Version = 0
+		}
+		err = p.repo.Patch(r.Context(), prod, columns)
+		if err != nil {
+			switch err {
+			case models.RecordNotFound:
+				problem.Write(w, r, problem.NotFound(err.Error()))
+			case models.ErrVersionMismatch:
+				problem.Write(w, r, problem.PreconditionFailed(err.Error()))
+			default:
+				problem.Write(w, r, problem.Internal(err))
+			}
 			return
+		}
+	}
+	w.Header().Set("ETag", etag(prod))
+	json, err := prod.ToJSON()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Write(json)
+}
+
+// DeleteProduct is the handler for the (soft) deletion of a single product. The product is only marked as deleted
+// and can be brought back using RestoreProduct.
+func (p *Products) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	// retrieve the id from the path
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest("{id} not found in the path"))
+		return
+	}
+	err = p.repo.Delete(r.Context(), id)
+	if err != nil {
+		switch err {
+		case models.RecordNotFound:
+			problem.Write(w, r, problem.NotFound(err.Error()))
 		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Write(w, r, problem.Internal(err))
 		}
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RestoreProduct is the handler to bring back a soft deleted product. The restored product is returned to the caller.
+func (p *Products) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	// retrieve the id from the path
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest("{id} not found in the path"))
+		return
+	}
+	err = p.repo.Restore(r.Context(), id)
+	if err != nil {
+		switch err {
+		case models.RecordNotFound:
+			problem.Write(w, r, problem.NotFound("deleted product not found"))
+		default:
+			problem.Write(w, r, problem.Internal(err))
+		}
+		return
+	}
+	prod, err := p.repo.Get(r.Context(), id, false)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Header().Set("ETag", etag(prod))
+	json, err := prod.ToJSON()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Write(json)
+}
+
+// includeDeletedParam reads the include_deleted query parameter. Only the roles with the products:read-deleted
+// permission are allowed to look at the soft deleted products, for everyone else asking for them is an error.
+func includeDeletedParam(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("include_deleted")
+	if len(v) == 0 {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil || !include {
+		return false, nil
+	}
+	if !hasPermission(r, security.PermProductsReadDeleted) {
+		return false, fmt.Errorf("include_deleted requires the %s permission", security.PermProductsReadDeleted)
+	}
+	return true, nil
+}
+
 // MiddlewareProductValidation is a function call before the effective function. Its scope is to unmarshall the json
 // object in the body of the request in a valid Product object, save this object into a new context, inject the new
 // context in the request and serve the next handler in the chain
 func (p *Products) MiddlewareProductValidation(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "MiddlewareProductValidation")
 		prod := &models.Product{}
 		if err := prod.FromJSON(r.Body); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			tracing.RecordError(span, err)
+			span.End()
+			problem.Write(w, r, problem.InvalidJSON(err))
 			return
 		}
 		if err := prod.Validate(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			tracing.RecordError(span, err)
+			span.End()
+			problem.Write(w, r, problem.Validation(err))
 			return
 		}
+		span.End()
 
 		// context creation to store product
 		ctx := context.WithValue(r.Context(), "prod", prod)
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/mas2020-golang/rest-api/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// filterParams reads the filters and the sort query parameters into the query
+func filterParams(r *http.Request, q *models.ProductQuery) error {
+	values := r.URL.Query()
+	price := func(name string) (*float32, error) {
+		v := values.Get(name)
+		if len(v) == 0 {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("%s must be a positive number", name)
+		}
+		price := float32(f)
+		return &price, nil
+	}
+	var err error
+	if q.PriceMin, err = price("price_min"); err != nil {
+		return err
+	}
+	if q.PriceMax, err = price("price_max"); err != nil {
+		return err
+	}
+	if q.PriceMin != nil && q.PriceMax != nil && *q.PriceMin > *q.PriceMax {
+		return fmt.Errorf("price_min can't be greater than price_max")
+	}
+	q.SKU = values.Get("sku")
+	q.SKUPrefix = values.Get("sku_prefix")
+	q.Name = values.Get("name")
+	q.Search = strings.TrimSpace(values.Get("q"))
+	if v := values.Get("sort"); len(v) > 0 {
+		if q.Sort, err = models.ParseSort(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageParams reads the limit, offset and cursor query parameters into the query. offset and cursor can't be used
+// together and the cursor is valid only for the same sort, so filterParams has to be called first.
+func pageParams(r *http.Request, q *models.ProductQuery) error {
+	values := r.URL.Query()
+	if v := values.Get("limit"); len(v) > 0 {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > models.MaxLimit {
+			return fmt.Errorf("limit must be a number between 1 and %d", models.MaxLimit)
+		}
+		q.Limit = limit
+	} else {
+		q.Limit = models.DefaultLimit
+	}
+	if v := values.Get("offset"); len(v) > 0 {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("offset must be a positive number")
+		}
+		q.Offset = offset
+	}
+	if v := values.Get("cursor"); len(v) > 0 {
+		if q.Offset > 0 {
+			return fmt.Errorf("offset and cursor can't be used together")
+		}
+		after, err := models.DecodeCursor(*q, v)
+		if err != nil {
+			return err
+		}
+		q.After = after
+	}
+	return nil
+}
+
+// setLinkHeader adds the RFC 5988 Link header to navigate the pages. The next link uses the cursor unless the caller
+// is already paging with offset, prev is given only for the offset pagination and last only when the caller is not
+// following a cursor (the position of a cursor in the collection is unknown).
+func setLinkHeader(w http.ResponseWriter, r *http.Request, q models.ProductQuery, page *models.ProductPage) {
+	var links []string
+	link := func(rel string, set map[string]string) {
+		values := r.URL.Query()
+		values.Del("cursor")
+		values.Del("offset")
+		values.Set("limit", strconv.Itoa(q.Limit))
+		for k, v := range set {
+			values.Set(k, v)
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+
+	link("first", nil)
+	if q.After != nil || q.Offset == 0 {
+		// cursor pagination
+		if len(page.NextCursor) > 0 {
+			link("next", map[string]string{"cursor": page.NextCursor})
+		}
+	} else {
+		// offset pagination
+		if q.Offset > 0 {
+			prev := q.Offset - q.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			link("prev", map[string]string{"offset": strconv.Itoa(prev)})
+		}
+		if len(page.NextCursor) > 0 {
+			link("next", map[string]string{"offset": strconv.Itoa(q.Offset + q.Limit)})
+		}
+	}
+	if q.After == nil && page.TotalCount > 0 {
+		last := (page.TotalCount - 1) / q.Limit * q.Limit
+		link("last", map[string]string{"offset": strconv.Itoa(last)})
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
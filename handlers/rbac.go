@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"net/http"
+	"strings"
+)
+
+// RequireRole lets go on only the callers having one of the given roles, it must be used after AuthMiddleware
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := roleOf(r)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			problem.Write(w, r, problem.Forbidden(fmt.Sprintf("one of the roles %s is required",
+				strings.Join(roles, ","))))
+		})
+	}
+}
+
+// RequirePermission lets go on only the callers whose role has been granted the permission (see
+// security.HasPermission), it must be used after AuthMiddleware
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasPermission(r, permission) {
+				problem.Write(w, r, problem.Forbidden(fmt.Sprintf("the %s permission is required", permission)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireBearer allows the call only if it is authenticated with an access token: a leaked api key can't be used to
+// logout or to create new api keys
+func RequireBearer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+		if claims["typ"] != accessToken {
+			problem.Write(w, r, problem.Forbidden("an access token is required, the call can't be "+
+				"authenticated with an api key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// roleOf returns the role claim injected into the request context by AuthMiddleware
+func roleOf(r *http.Request) string {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	return role
+}
+
+// hasPermission returns true if the role of the caller has been granted the permission
+func hasPermission(r *http.Request, permission string) bool {
+	return security.HasPermission(roleOf(r), permission)
+}
@@ -2,39 +2,57 @@ package handlers
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/mas2020-golang/goutils/output"
+	"github.com/google/uuid"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/metrics"
 	"github.com/mas2020-golang/rest-api/models"
-	"github.com/mas2020-golang/rest-api/utils"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// AuthMiddleware authenticates the call with the access token in the Authorization header, that must be correctly
+// signed, not expired and not revoked, or with the api key in the X-API-Key header. The claims are injected into the
+// request context, for an api key they are the ones an access token of the user would have.
+func (l *LoginResource) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		// starts with Bearer?
-		if !strings.HasPrefix(token, "Bearer") {
-			w.Header().Set("Content-Type", "application/json")
-			utils.ReturnError(&w, "token is wrong/missing", http.StatusUnauthorized)
-			return
+		var (
+			mapClaims jwt.MapClaims
+			err       error
+		)
+		// the span measures only the check of the credentials, not the next handler
+		ctx, span := tracing.Start(r.Context(), "AuthMiddleware")
+		if key := r.Header.Get(apiKeyHeader); len(key) > 0 {
+			span.SetAttributes(attribute.String("auth.method", "api_key"))
+			mapClaims, err = l.verifyApiKey(ctx, key)
+		} else {
+			span.SetAttributes(attribute.String("auth.method", "bearer"))
+			mapClaims, err = l.verifyBearer(ctx, r.Header.Get("Authorization"))
 		}
-		// verify the token
-		claims, err := verifyToken(token)
 		if err != nil {
-			utils.ReturnError(&w, err.Error(), http.StatusUnauthorized)
+			tracing.RecordError(span, err)
+			span.End()
+			problem.Write(w, r, err)
 			return
 		}
-		mapClaims := claims.(jwt.MapClaims)
-		output.DebugLog("", fmt.Sprintf("received these claims: %v", claims))
+		// the user is added to the access log and to the logger of the request
+		name, _ := mapClaims["name"].(string)
+		span.SetAttributes(attribute.String("enduser.id", name))
+		span.End()
+		ctx = logging.SetUser(r.Context(), name)
+		logging.FromContext(ctx).Debugf("received these claims: %v", mapClaims)
 		// inject token info into the call context
-		// context creation to store product
-		ctx := context.WithValue(r.Context(), "claims", mapClaims)
+		ctx = context.WithValue(ctx, "claims", mapClaims)
 		// create a new request with the new context
 		req := r.WithContext(ctx)
 
@@ -43,16 +61,151 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// LoginResource is a struct to manage the /login handler funcs
+// verifyBearer checks the access token of the Authorization header, the error is the problem to return
+func (l *LoginResource) verifyBearer(ctx context.Context, token string) (jwt.MapClaims, error) {
+	// starts with Bearer?
+	if !strings.HasPrefix(token, "Bearer") {
+		return nil, problem.Unauthorized("token is wrong/missing")
+	}
+	// verify the token, locally or with the identity provider that issued it
+	var (
+		mapClaims jwt.MapClaims
+		err       error
+	)
+	token = strings.TrimSpace(token[len("Bearer"):])
+	if l.oidc != nil && issuerOf(token) == l.oidc.Issuer() {
+		if mapClaims, err = l.oidc.Verify(ctx, token); err == nil {
+			mapClaims["typ"] = accessToken
+		}
+	} else {
+		mapClaims, err = l.verifyToken(token, accessToken)
+	}
+	if err != nil {
+		return nil, problem.Unauthorized(err.Error())
+	}
+	jti, _ := mapClaims["jti"].(string)
+	revoked, err := l.tokens.IsRevoked(ctx, jti)
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	if revoked {
+		return nil, problem.Unauthorized("token has been revoked")
+	}
+	return mapClaims, nil
+}
+
+// verifyApiKey checks the api key of the X-API-Key header: it must exist, not be revoked nor expired and its user
+// must be enabled. The error is the problem to return.
+func (l *LoginResource) verifyApiKey(ctx context.Context, key string) (jwt.MapClaims, error) {
+	failed := problem.Unauthorized("api key is wrong, expired or revoked")
+	prefix, ok := security.ApiKeyPrefix(key)
+	if !ok {
+		return nil, failed
+	}
+	stored, err := l.apiKeys.GetByPrefix(ctx, prefix)
+	if err == models.ErrApiKeyNotFound {
+		return nil, failed
+	}
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	if !security.VerifyApiKey(key, stored.Hash) || !stored.Valid(time.Now()) {
+		return nil, failed
+	}
+	user, err := l.users.Get(ctx, stored.UserID)
+	if err == models.ErrUserNotFound || (err == nil && user.Disabled) {
+		return nil, failed
+	}
+	if err != nil {
+		return nil, problem.Internal(err)
+	}
+	if err = l.apiKeys.Touch(ctx, stored.ID); err != nil {
+		logging.FromContext(ctx).Errorf("last use of the api key %s not saved: %s", stored.Prefix, err.Error())
+	}
+	return jwt.MapClaims{
+		"name": user.Username,
+		"role": security.RoleOf(user.UserType),
+		"typ":  apiKeyToken,
+		"kid":  stored.ID,
+	}, nil
+}
+
+// results of the logins counted by loginsTotal
+const (
+	loginSuccess = "success"
+	// loginFailure is a wrong username or password or a disabled user
+	loginFailure = "failure"
+	// loginLocked is a login of a user locked after too many failures
+	loginLocked = "locked"
+	// loginThrottled is a login from a client IP blocked after too many failures
+	loginThrottled = "throttled"
+)
+
+// loginsTotal counts the logins by result
+var loginsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_logins_total",
+	Help: "Number of logins by result.",
+}, []string{"result"})
+
+func init() {
+	metrics.Default.MustRegister(loginsTotal)
+}
+
+// LoginResource is a struct to manage the /login, /token/refresh and /logout handler funcs and the authentication
+// of the other calls
 type LoginResource struct {
-	pool *pgxpool.Pool
+	users   models.UserRepository
+	hasher  security.PasswordHasher
+	tokens  models.TokenRepository
+	keys    *security.KeySet
+	apiKeys models.ApiKeyRepository
+	oidc    *security.OIDCVerifier
+	// brute force protection
+	userPolicy security.LoginPolicy
+	// unknownUsers counts the failures of the usernames that don't exist with the policy of the users, so that the
+	// responses don't reveal which users exist
+	unknownUsers      *security.Throttle
+	ipThrottle        *security.Throttle
+	trustForwardedFor bool
+}
+
+// NewLogin returns the login handler checking the credentials on the given repository. The passwords stored with
+// a different algorithm or parameters than the ones of hasher are rehashed at the first successful login. The
+// refresh tokens and the revoked access tokens are kept in tokens, keys signs and verifies them. The api keys are
+// read from apiKeys.
+func NewLogin(users models.UserRepository, hasher security.PasswordHasher, tokens models.TokenRepository,
+	keys *security.KeySet, apiKeys models.ApiKeyRepository) *LoginResource {
+	return &LoginResource{users: users, hasher: hasher, tokens: tokens, keys: keys, apiKeys: apiKeys,
+		userPolicy: security.DefaultUserLoginPolicy, unknownUsers: security.NewThrottle(security.DefaultUserLoginPolicy),
+		ipThrottle: security.NewThrottle(security.DefaultIPLoginPolicy)}
+}
+
+// SetLoginPolicies replaces the default policies applied to the failed logins of a user and of a client IP. If
+// trustForwardedFor is true the client IP is read from the X-Forwarded-For header set by the proxies.
+func (l *LoginResource) SetLoginPolicies(user, ip security.LoginPolicy, trustForwardedFor bool) {
+	l.userPolicy = user.WithDefaults(security.DefaultUserLoginPolicy)
+	l.unknownUsers = security.NewThrottle(l.userPolicy)
+	l.ipThrottle = security.NewThrottle(ip.WithDefaults(security.DefaultIPLoginPolicy))
+	l.trustForwardedFor = trustForwardedFor
 }
 
-func NewLogin(pool *pgxpool.Pool) *LoginResource {
-	return &LoginResource{pool}
+// SetOIDC makes AuthMiddleware accept also the access tokens issued by the identity provider of the verifier, the
+// local tokens are still accepted
+func (l *LoginResource) SetOIDC(verifier *security.OIDCVerifier) {
+	l.oidc = verifier
 }
 
-// Login verifies username and password and create a JWT token in return.
+// issuerOf returns the iss claim of the token without verifying it, only to choose how to verify it
+func issuerOf(token string) string {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+	iss, _ := claims["iss"].(string)
+	return iss
+}
+
+// Login verifies username and password and create a pair of access and refresh tokens in return.
 func (l *LoginResource) Login(w http.ResponseWriter, r *http.Request) {
 	// unmarshall json body
 	var jBody map[string]string
@@ -61,74 +214,137 @@ func (l *LoginResource) Login(w http.ResponseWriter, r *http.Request) {
 
 	username := jBody["username"]
 	password := jBody["password"]
-	output.InfoLog("", fmt.Sprintf(`POST /login {"username": "%s"}`, username))
+	logging.FromContext(r.Context()).WithField("username", username).Debug("login attempt")
 
 	if len(username) == 0 || len(password) == 0 {
-		utils.ReturnError(&w, "please provide username and password to get the token", http.StatusBadRequest)
+		problem.Write(w, r, problem.BadRequest("please provide username and password to get the token"))
 		return
 	}
 
-	// check the username and password into the database
-	// get the sha256 for the input password
-	h := sha256.New()
-	h.Write([]byte(password))
-	password = fmt.Sprintf("%x", h.Sum(nil))
+	// the clients with too many failed logins have to wait
+	ip := l.clientIP(r)
+	if wait := l.ipThrottle.RetryAfter(ip); wait > 0 {
+		loginsTotal.WithLabelValues(loginThrottled).Inc()
+		tooManyLogins(w, r, wait)
+		return
+	}
 
-	user, err := models.Users.SearchByUserPwd(l.pool, username, password)
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "no rows") {
-			utils.ReturnError(&w, "authentication failed", http.StatusUnauthorized)
-		} else {
-			utils.ReturnError(&w, "error during credential check:"+err.Error(), http.StatusInternalServerError)
+	// check the username and password: the hash is verified here and not in the query to compare it in
+	// constant time
+	user, err := l.users.GetByUsername(r.Context(), username)
+	if err != nil && err != models.ErrUserNotFound {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	if err == models.ErrUserNotFound {
+		// an unknown user is locked like an existing one, otherwise the 429 would tell which users exist
+		if wait := l.unknownUsers.RetryAfter(username); wait > 0 {
+			loginsTotal.WithLabelValues(loginLocked).Inc()
+			tooManyLogins(w, r, wait)
+			return
 		}
+		// hash anyway to not reveal with the response time that the user doesn't exist
+		l.hasher.Hash(password)
+		l.ipThrottle.Fail(ip)
+		l.unknownUsers.Fail(username)
+		loginsTotal.WithLabelValues(loginFailure).Inc()
+		problem.Write(w, r, problem.Unauthorized("authentication failed"))
 		return
 	}
-	output.TraceLog("", fmt.Sprintf("load user: %#v", user))
-	// create the token
-	token, err := createToken(username)
+	if user.LockedUntil != nil && time.Until(*user.LockedUntil) > 0 {
+		loginsTotal.WithLabelValues(loginLocked).Inc()
+		tooManyLogins(w, r, time.Until(*user.LockedUntil))
+		return
+	}
+	ok, rehash, err := security.VerifyPassword(l.hasher, password, user.ApiKey)
 	if err != nil {
-		utils.ReturnError(&w, "please provide username and password to get the token", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Errorf("password of the user '%s' can't be verified: %s", username,
+			err.Error())
+	}
+	if !ok {
+		l.ipThrottle.Fail(ip)
+		l.loginFailed(r.Context(), user)
+		loginsTotal.WithLabelValues(loginFailure).Inc()
+		problem.Write(w, r, problem.Unauthorized("authentication failed"))
 		return
 	}
-	w.Header().Set("Authorization", "Bearer "+token)
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(fmt.Sprintf(`{"token": "%s"}`, token)))
+	if user.Disabled {
+		loginsTotal.WithLabelValues(loginFailure).Inc()
+		problem.Write(w, r, problem.Unauthorized("authentication failed"))
+		return
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err = l.users.SetLockedUntil(r.Context(), user.ID, nil, true); err != nil {
+			logging.FromContext(r.Context()).Errorf("failed logins of the user '%s' not reset: %s", username,
+				err.Error())
+		}
+	}
+	if rehash {
+		l.rehash(r.Context(), user, password)
+	}
+	logging.FromContext(r.Context()).Tracef("load user: %#v", user)
+	logging.SetUser(r.Context(), user.Username)
+	// create the tokens, a new family of refresh tokens starts here
+	loginsTotal.WithLabelValues(loginSuccess).Inc()
+	l.writeTokens(w, r, user, uuid.NewString())
 }
 
-// createToken creates the JWT token
-func createToken(name string) (string, error) {
-	signingKey := []byte(utils.Server.TokenPwd)
-	// set expiration to 30 seconds
-	expTime := time.Now().Add(5 * time.Minute).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"name": name,
-		"role": "admin",
-		"exp":  expTime,
-	})
-	tokenString, err := token.SignedString(signingKey)
-	return tokenString, err
+// loginFailed counts the failed login of the user and refuses the next logins for the time given by the policy. A
+// failure is only logged: the IP throttling still protects the user.
+func (l *LoginResource) loginFailed(ctx context.Context, user *models.User) {
+	// the failures older than the lockout are not consecutive anymore
+	if user.LockedUntil != nil && time.Since(*user.LockedUntil) > l.userPolicy.Lockout {
+		if err := l.users.SetLockedUntil(ctx, user.ID, nil, true); err != nil {
+			logging.FromContext(ctx).Errorf("failed logins of the user '%s' not reset: %s", user.Username,
+				err.Error())
+		}
+	}
+	failures, err := l.users.AddLoginFailure(ctx, user.ID)
+	if err == nil {
+		delay, lockout := l.userPolicy.Delay(failures)
+		if lockout {
+			logging.FromContext(ctx).Warnf("user '%s' locked for %s after %d failed logins", user.Username, delay,
+				failures)
+		}
+		until := time.Now().Add(delay)
+		err = l.users.SetLockedUntil(ctx, user.ID, &until, lockout)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("failed login of the user '%s' not saved: %s", user.Username,
+			err.Error())
+	}
 }
 
-// verifyToken checks the token to confirm that is correctly signed and not expired
-func verifyToken(token string) (jwt.Claims, error) {
-	// check signature
-	token = token[7:]
-	if len(token) == 0 {
-		return nil, fmt.Errorf("token has an incorrect format")
+// clientIP returns the address of the client, the first address of X-Forwarded-For if the proxies are trusted
+func (l *LoginResource) clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); l.trustForwardedFor && len(forwarded) > 0 {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
 	}
-	signingKey := []byte(utils.Server.TokenPwd)
-	t, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return signingKey, nil
-	})
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return nil, err
+		return r.RemoteAddr
 	}
-	exp, ok := t.Claims.(jwt.MapClaims)["exp"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("incorrect exp payload property")
+	return host
+}
+
+// tooManyLogins returns 429 telling the client how many seconds to wait in the Retry-After header
+func tooManyLogins(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	problem.Write(w, r, problem.TooManyRequests(fmt.Sprintf("too many failed logins, retry in %d seconds", seconds)))
+}
+
+// rehash replaces the stored password hash with a new one created by the current hasher. A failure is only logged,
+// the login goes on and the hash will be replaced at the next login.
+func (l *LoginResource) rehash(ctx context.Context, user *models.User, password string) {
+	hash, err := l.hasher.Hash(password)
+	if err == nil {
+		err = l.users.SetApiKey(ctx, user.ID, hash)
 	}
-	if ok := t.Claims.(jwt.MapClaims).VerifyExpiresAt(int64(exp), false); !ok {
-		return nil, fmt.Errorf("token expired")
+	if err != nil {
+		logging.FromContext(ctx).Errorf("password of the user '%s' can't be rehashed: %s", user.Username,
+			err.Error())
+		return
 	}
-	return t.Claims, nil
+	logging.FromContext(ctx).Infof("password of the user '%s' has been rehashed", user.Username)
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// token types, stored into the typ claim so that a refresh token can't be used as an access token
+const (
+	accessToken  = "access"
+	refreshToken = "refresh"
+	// apiKeyToken is the typ of the claims of the calls authenticated with an api key
+	apiKeyToken = "api-key"
+)
+
+// apiKeyHeader is the header with the api key
+const apiKeyHeader = "X-API-Key"
+
+// default lifetime of the tokens, used if the configuration doesn't set them
+const (
+	defaultAccessTTL  = 5 * time.Minute
+	defaultRefreshTTL = 24 * time.Hour
+)
+
+// tokenResponse is returned by Login and Refresh
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// refreshRequest is the body of Refresh and Logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new pair of access and refresh tokens. Every refresh token can be used
+// only once: if a used token comes back it has probably been stolen, so the whole family it belongs to is revoked
+// and both the thief and the legitimate client have to login again.
+func (l *LoginResource) Refresh(w http.ResponseWriter, r *http.Request) {
+	var body refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.RefreshToken) == 0 {
+		problem.Write(w, r, problem.BadRequest("please provide the refresh_token"))
+		return
+	}
+	claims, err := l.verifyToken(body.RefreshToken, refreshToken)
+	if err != nil {
+		problem.Write(w, r, problem.Unauthorized(err.Error()))
+		return
+	}
+	jti, _ := claims["jti"].(string)
+	stored, err := l.tokens.UseRefresh(r.Context(), jti)
+	switch err {
+	case nil:
+	case models.ErrTokenReused:
+		logging.FromContext(r.Context()).Warnf("refresh token reused for the user '%s', the family %s is revoked",
+			stored.Username, stored.Family)
+		if err = l.tokens.RevokeFamily(r.Context(), stored.Family); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+		problem.Write(w, r, problem.Unauthorized(models.ErrTokenReused.Error()))
+		return
+	case models.ErrTokenNotFound, models.ErrTokenRevoked:
+		problem.Write(w, r, problem.Unauthorized(err.Error()))
+		return
+	default:
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+
+	// the user could have been disabled in the meantime
+	user, err := l.users.GetByUsername(r.Context(), stored.Username)
+	if err == models.ErrUserNotFound || (err == nil && user.Disabled) {
+		l.tokens.RevokeFamily(r.Context(), stored.Family)
+		problem.Write(w, r, problem.Unauthorized("user not found or disabled"))
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	l.writeTokens(w, r, user, stored.Family)
+}
+
+// Logout revokes the access token used to call it and, if it is given in the body, the family of the refresh token
+func (l *LoginResource) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	name, _ := claims["name"].(string)
+	var body refreshRequest
+	json.NewDecoder(r.Body).Decode(&body)
+
+	// the tokens of an identity provider could have no jti
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if len(jti) > 0 {
+		if err := l.tokens.Revoke(r.Context(), jti, time.Unix(int64(exp), 0)); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+	}
+	if len(body.RefreshToken) > 0 {
+		refresh, err := l.verifyToken(body.RefreshToken, refreshToken)
+		if err != nil || refresh["name"] != name {
+			problem.Write(w, r, problem.BadRequest("the refresh_token is not valid for the user"))
+			return
+		}
+		family, _ := refresh["fam"].(string)
+		if err = l.tokens.RevokeFamily(r.Context(), family); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTokens creates a new pair of access and refresh tokens for the user, the refresh token belongs to the given
+// family
+func (l *LoginResource) writeTokens(w http.ResponseWriter, r *http.Request, user *models.User, family string) {
+	accessTTL, refreshTTL := tokenTTL()
+	access, err := IssueAccessToken(l.keys, user, accessTTL)
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	stored := &models.RefreshToken{
+		JTI:       uuid.NewString(),
+		Family:    family,
+		Username:  user.Username,
+		ExpiresOn: time.Now().Add(refreshTTL),
+	}
+	refresh, err := l.keys.Sign(jwt.MapClaims{
+		"name": user.Username,
+		"typ":  refreshToken,
+		"jti":  stored.JTI,
+		"fam":  stored.Family,
+		"exp":  stored.ExpiresOn.Unix(),
+	})
+	if err == nil {
+		err = l.tokens.AddRefresh(r.Context(), stored)
+	}
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+
+	body, err := json.Marshal(tokenResponse{access, refresh, "Bearer", int(accessTTL.Seconds())})
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Header().Set("Authorization", "Bearer "+access)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// IssueAccessToken returns an access token of the user valid for ttl signed by the active key of keys, the same
+// token returned by the login
+func IssueAccessToken(keys *security.KeySet, user *models.User, ttl time.Duration) (string, error) {
+	return keys.Sign(jwt.MapClaims{
+		"name": user.Username,
+		"role": security.RoleOf(user.UserType),
+		"typ":  accessToken,
+		"jti":  uuid.NewString(),
+		"exp":  time.Now().Add(ttl).Unix(),
+	})
+}
+
+// tokenTTL returns the lifetime of the access and refresh tokens
+func tokenTTL() (access, refresh time.Duration) {
+	access, refresh = utils.Server.Token.AccessTTL, utils.Server.Token.RefreshTTL
+	if access <= 0 {
+		access = defaultAccessTTL
+	}
+	if refresh <= 0 {
+		refresh = defaultRefreshTTL
+	}
+	return access, refresh
+}
+
+// JWKS returns the public keys verifying the tokens, the clients can cache them for a while: a new key is
+// published before it starts signing and a retired key is kept until its tokens are expired
+func (l *LoginResource) JWKS(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(l.keys.JWKS())
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(body)
+}
+
+// verifyToken checks the token to confirm that is correctly signed by one of the keys, not expired and of the
+// expected type
+func (l *LoginResource) verifyToken(token, typ string) (jwt.MapClaims, error) {
+	token = strings.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token has an incorrect format")
+	}
+	// the expiration and the algorithm are checked by Parse
+	claims := jwt.MapClaims{}
+	if _, err := l.keys.Parse(token, claims); err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, fmt.Errorf("incorrect exp payload property")
+	}
+	if claims["typ"] != typ {
+		return nil, fmt.Errorf("wrong token type, %s token expected", typ)
+	}
+	return claims, nil
+}
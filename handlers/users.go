@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"net/http"
+	"strconv"
+)
+
+// Users is the handler for the /users resources, all of them require the users:manage permission
+type Users struct {
+	repo   models.UserRepository
+	hasher security.PasswordHasher
+}
+
+// NewUsers returns the users handler reading and writing through the given repository, the passwords are hashed
+// using hasher
+func NewUsers(repo models.UserRepository, hasher security.PasswordHasher) *Users {
+	return &Users{repo, hasher}
+}
+
+// GetUsers returns all the users
+func (u *Users) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := u.repo.GetAll(r.Context())
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	json, err := users.ToJSON()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.Write(json)
+}
+
+// GetUser returns a single user
+func (u *Users) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := u.repo.Get(r.Context(), id)
+	if err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// AddUser creates a new user, the password is mandatory
+func (u *Users) AddUser(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := readUser(w, r)
+	if !ok {
+		return
+	}
+	if len(password) == 0 {
+		problem.Write(w, r, problem.BadRequest("password is mandatory"))
+		return
+	}
+	user.UserType = security.RoleOf(user.UserType)
+	var err error
+	if user.ApiKey, err = u.hasher.Hash(password); err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	if err = u.repo.Add(r.Context(), user); err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	writeUser(w, r, http.StatusCreated, user)
+}
+
+// UpdateUser replaces a user, the password is changed only if it is given
+func (u *Users) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, password, ok := readUser(w, r)
+	if !ok {
+		return
+	}
+	user.ID = id
+	user.UserType = security.RoleOf(user.UserType)
+	if len(password) > 0 {
+		var err error
+		if user.ApiKey, err = u.hasher.Hash(password); err != nil {
+			problem.Write(w, r, problem.Internal(err))
+			return
+		}
+	}
+	if err := u.repo.Update(r.Context(), user); err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// DeleteUser removes a user
+func (u *Users) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := u.repo.Delete(r.Context(), id); err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnableUser is the handler for POST /users/{id}/enable, it also unlocks a user locked by too many failed logins
+func (u *Users) EnableUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, false)
+}
+
+// DisableUser is the handler for POST /users/{id}/disable, a disabled user can't login anymore
+func (u *Users) DisableUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, true)
+}
+
+// setDisabled changes the Disabled flag of the user and returns the updated user
+func (u *Users) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := u.repo.SetDisabled(r.Context(), id, disabled); err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).WithField("user-id", id).Infof("user disabled: %v", disabled)
+	// enabling a user also unlocks it after too many failed logins
+	if !disabled {
+		if err := u.repo.SetLockedUntil(r.Context(), id, nil, true); err != nil {
+			returnUserError(w, r, err)
+			return
+		}
+	}
+	user, err := u.repo.Get(r.Context(), id)
+	if err != nil {
+		returnUserError(w, r, err)
+		return
+	}
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// readUser decodes and validates the user in the body returning it with the password. In case of error the
+// response is already written and ok is false.
+func readUser(w http.ResponseWriter, r *http.Request) (user *models.User, password string, ok bool) {
+	in := &models.UserInput{}
+	if err := in.FromJSON(r.Body); err != nil {
+		problem.Write(w, r, problem.InvalidJSON(err))
+		return nil, "", false
+	}
+	user = in.User()
+	if err := user.Validate(); err != nil {
+		problem.Write(w, r, problem.Validation(err))
+		return nil, "", false
+	}
+	return user, in.Password, true
+}
+
+// writeUser writes the user with the given status code
+func writeUser(w http.ResponseWriter, r *http.Request, code int, user *models.User) {
+	json, err := user.ToJSON()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	w.WriteHeader(code)
+	w.Write(json)
+}
+
+// returnUserError maps the repository errors to the response status code
+func returnUserError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case models.ErrUserNotFound:
+		problem.Write(w, r, problem.NotFound(err.Error()))
+	case models.ErrUserExists:
+		problem.Write(w, r, problem.Conflict(err.Error()))
+	default:
+		problem.Write(w, r, problem.Internal(err))
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Package health answers the probes of the orchestrator.
+
+The liveness probe (LiveHandler) only tells that the process serves the requests. The readiness probe
+(ReadyHandler) runs the checks registered by the dependencies of the application (database, migrations...) and is
+unready if one of them fails or once the shutdown of the application has begun.
+*/
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is the time a check has to complete
+const DefaultTimeout = 2 * time.Second
+
+// status of the application and of the checks
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+	// StatusShuttingDown is the status of the application once its shutdown has begun
+	StatusShuttingDown = "shutting_down"
+)
+
+// Check verifies a dependency of the application, it returns an error if the dependency can't be used. The message
+// describes the state of the dependency (e.g. the version of the schema), it can be empty.
+type Check func(ctx context.Context) (message string, err error)
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Message   string  `json:"message,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body of the readiness probe
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs the checks of the readiness probe, it is safe for concurrent use
+type Checker struct {
+	timeout      time.Duration
+	mu           sync.Mutex
+	checks       map[string]Check
+	shuttingDown int32
+}
+
+// New returns a checker without checks giving timeout to every check
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Register adds the check of a dependency, it replaces the check with the same name
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Shutdown makes the application unready, it is called when the shutdown begins so that the load balancers stop
+// sending new requests while the ones in flight are drained
+func (c *Checker) Shutdown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+// ShuttingDown reports whether Shutdown has been called
+func (c *Checker) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
+
+// Ready runs the checks concurrently and returns their results sorted by name. The status is up only if every
+// check succeeded and the shutdown has not begun.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(names))}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, name, checks[name])
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	if c.ShuttingDown() {
+		report.Status = StatusShuttingDown
+	}
+	return report
+}
+
+// run runs a check with the timeout, a check not returning in time or panicking is failed
+func (c *Checker) run(ctx context.Context, name string, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- Result{Status: StatusDown, Error: fmt.Sprintf("the check panicked: %v", r)}
+			}
+		}()
+		message, err := check(ctx)
+		if err != nil {
+			done <- Result{Status: StatusDown, Message: message, Error: err.Error()}
+			return
+		}
+		done <- Result{Status: StatusUp, Message: message}
+	}()
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = Result{Status: StatusDown, Error: fmt.Sprintf("no answer within %s", c.timeout)}
+	}
+	result.Name = name
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	return result
+}
+
+// LiveHandler answers the liveness probe: 200 as long as the process serves the requests, also during the shutdown
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusUp})
+	})
+}
+
+// ReadyHandler answers the readiness probe with the report of the checks: 200 if the application is ready, 503
+// otherwise
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+// writeJSON writes the body, the probes must not be cached
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
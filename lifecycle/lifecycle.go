@@ -0,0 +1,96 @@
+/*
+Package lifecycle stops the application in order.
+
+The resources opened while the application starts (the database pool, the exporter of the spans...) register a
+closer on the Manager; when the application stops, after the HTTP servers have drained the requests in flight, the
+closers are called in reverse order of registration, so that a resource is closed before the ones it depends on.
+*/
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Closer releases a resource, it should return when ctx is done
+type Closer func(ctx context.Context) error
+
+// named is a registered closer
+type named struct {
+	name  string
+	close Closer
+}
+
+// Manager keeps the closers of the application, it is safe for concurrent use
+type Manager struct {
+	mu      sync.Mutex
+	closers []named
+	closed  bool
+}
+
+// New returns a manager without closers
+func New() *Manager {
+	return &Manager{}
+}
+
+// Register adds a closer, the name identifies it in the errors
+func (m *Manager) Register(name string, close Closer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closers = append(m.closers, named{name, close})
+}
+
+// Close calls the closers in reverse order of registration, also when one of them fails. The error reports every
+// closer that failed. The calls after the first one do nothing.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	closers := m.closers
+	m.mu.Unlock()
+
+	var errs Errors
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", closers[i].name, err))
+		}
+	}
+	return errs.Err()
+}
+
+// Errors are the errors of the steps of the shutdown
+type Errors []error
+
+// Err returns nil if there are no errors, the only error or all of them
+func (e Errors) Err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// Error joins the messages
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// SignalContext returns a copy of parent that is done when the process receives SIGINT (Ctrl+C) or SIGTERM (the
+// signal sent by Docker and Kubernetes), stop releases the signals
+func SignalContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/utils"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http"
+	"time"
+)
+
+// requestInfo is what the handlers tell about the request to the access log
+type requestInfo struct {
+	user string
+}
+
+// AccessLog writes a record for every request with method, route template, status, bytes, duration, remote IP,
+// authenticated user and request id. The logger of the request, with the request_id field, is stored into the
+// request context (see FromContext), with the trace_id field if the request is traced. It must be used after
+// utils.RequestIDMiddleware and tracing.Middleware.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		logger := logrus.WithField("request_id", utils.RequestID(r.Context()))
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.WithField("trace_id", sc.TraceID().String())
+		}
+		ctx := context.WithValue(WithLogger(r.Context(), logger), requestKey, info)
+		rec := utils.NewStatusRecorder(w)
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		record := logger.WithFields(logrus.Fields{
+			"method":      r.Method,
+			"route":       routeOf(r),
+			"path":        r.URL.Path,
+			"status":      rec.Status(),
+			"bytes":       rec.Bytes(),
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip":   remoteIP(r),
+		})
+		if len(info.user) > 0 {
+			record = record.WithField("user", info.user)
+		}
+		if rec.Status() >= http.StatusInternalServerError {
+			record.Error("request served")
+		} else {
+			record.Info("request served")
+		}
+	})
+}
+
+// routeOf returns the template of the route matched by mux (e.g. /products/{id:[0-9]+}), empty if none matched
+func routeOf(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return template
+}
+
+// remoteIP returns the address of the client without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
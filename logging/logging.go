@@ -0,0 +1,75 @@
+/*
+Package logging configures the logger of the application and gives every request its own logger.
+
+The logger of a request has the request_id field (and the user field once the caller is authenticated), it is
+stored into the request context by AccessLog and is read by handlers and models with FromContext.
+*/
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/mas2020-golang/goutils/output"
+	"github.com/sirupsen/logrus"
+	"io"
+)
+
+// log formats
+const (
+	// FormatText is the human readable format of the console (default)
+	FormatText = "text"
+	// FormatJSON writes a JSON object for every record
+	FormatJSON = "json"
+	// FormatLogfmt writes the records as key=value pairs
+	FormatLogfmt = "logfmt"
+)
+
+// contextKey is the type of the keys stored by this package into the request context
+type contextKey string
+
+const (
+	loggerKey  contextKey = "logger"
+	requestKey contextKey = "request"
+)
+
+// Setup sets level, format and output of the standard logrus logger, the one used by the whole application
+func Setup(level int, format string, out io.Writer) error {
+	var formatter logrus.Formatter
+	switch format {
+	case FormatText, "":
+		formatter = &output.TextFormatter{}
+	case FormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	case FormatLogfmt:
+		formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	default:
+		return fmt.Errorf("unknown log format '%s', it must be %s, %s or %s", format, FormatText, FormatJSON,
+			FormatLogfmt)
+	}
+	logrus.SetLevel(logrus.Level(level))
+	logrus.SetFormatter(formatter)
+	logrus.SetOutput(out)
+	return nil
+}
+
+// WithLogger returns a copy of ctx with the logger
+func WithLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger of the request, the standard logger if ctx has none
+func FromContext(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return logger
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// SetUser records the authenticated user of the request: it is written in the access log record and it is a field
+// of the logger of the returned context
+func SetUser(ctx context.Context, user string) context.Context {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		info.user = user
+	}
+	return WithLogger(ctx, FromContext(ctx).WithField("user", user))
+}
@@ -1,18 +1,10 @@
 package main
 
 import (
-	"github.com/mas2020-golang/rest-api/server"
+	"github.com/mas2020-golang/rest-api/cli"
 	"os"
 )
 
 func main() {
-	// start the application
-	a := server.App{}
-	a.Initialize(
-		os.Getenv("APP_DB_USERNAME"),
-		os.Getenv("APP_DB_PASSWORD"),
-		os.Getenv("APP_DB_HOST"),
-		os.Getenv("APP_DB_NAME"))
-
-	a.Run(":9090")
+	os.Exit(cli.New().Run(os.Args[1:]))
 }
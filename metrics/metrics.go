@@ -0,0 +1,41 @@
+/*
+Package metrics exposes the metrics of the application in the Prometheus format with the Prometheus client library.
+
+The metrics are registered into Default: the counters and the histograms with labels are updated by the code, the
+collectors of the Go runtime, of the process and of the database pool read their values at every scrape.
+*/
+package metrics
+
+import (
+	"crypto/subtle"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Default is the registry of the metrics of the application
+var Default = prometheus.NewRegistry()
+
+func init() {
+	Default.MustRegister(collectors.NewGoCollector())
+	Default.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler returns the handler serving the metrics of gatherer. If token is not empty the callers must send it as
+// bearer token in the Authorization header.
+func Handler(gatherer prometheus.Gatherer, token string) http.Handler {
+	metrics := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(token) > 0 {
+			auth := req.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				problem.Write(w, req, problem.Unauthorized("the metrics token is wrong or missing"))
+				return
+			}
+		}
+		metrics.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolMetric is a statistic of the pool
+type poolMetric struct {
+	desc  *prometheus.Desc
+	typ   prometheus.ValueType
+	value func(s *pgxpool.Stat) float64
+}
+
+// newPoolMetric returns the description of a statistic of the pool
+func newPoolMetric(name, help string, typ prometheus.ValueType, value func(s *pgxpool.Stat) float64) poolMetric {
+	return poolMetric{prometheus.NewDesc(name, help, nil, nil), typ, value}
+}
+
+// poolMetrics are the statistics of the pool exposed
+var poolMetrics = []poolMetric{
+	newPoolMetric("pgxpool_acquired_conns", "Number of connections currently in use.", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+	newPoolMetric("pgxpool_idle_conns", "Number of idle connections in the pool.", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+	newPoolMetric("pgxpool_total_conns", "Number of connections in the pool, in use, idle and being opened.",
+		prometheus.GaugeValue, func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+	newPoolMetric("pgxpool_max_conns", "Maximum number of connections of the pool.", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }),
+	newPoolMetric("pgxpool_acquire_count_total", "Number of connections acquired from the pool.",
+		prometheus.CounterValue, func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }),
+	newPoolMetric("pgxpool_acquire_duration_seconds_total", "Total time spent waiting for a connection.",
+		prometheus.CounterValue, func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }),
+	newPoolMetric("pgxpool_empty_acquire_count_total", "Number of acquires that had to wait for a connection.",
+		prometheus.CounterValue, func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }),
+	newPoolMetric("pgxpool_canceled_acquire_count_total", "Number of acquires canceled by their context.",
+		prometheus.CounterValue, func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }),
+}
+
+// PoolCollector exposes the statistics of a pool of database connections
+type PoolCollector struct {
+	pool *pgxpool.Pool
+}
+
+// NewPoolCollector returns the collector of the statistics of pool
+func NewPoolCollector(pool *pgxpool.Pool) *PoolCollector {
+	return &PoolCollector{pool}
+}
+
+// Describe implements prometheus.Collector
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range poolMetrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	for _, m := range poolMetrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.typ, m.value(s))
+	}
+}
+
+// RegisterPool registers the collector of pool into Default, replacing the one of a previous pool
+func RegisterPool(pool *pgxpool.Pool) {
+	c := NewPoolCollector(pool)
+	var registered prometheus.AlreadyRegisteredError
+	if err := Default.Register(c); errors.As(err, &registered) {
+		Default.Unregister(registered.ExistingCollector)
+		Default.MustRegister(c)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Package migrations keeps the database schema up to date. The migrations are the SQL files embedded from the sql
+folder, named as <version>_<name>.up.sql and <version>_<name>.down.sql. The applied migrations are recorded in the
+schema_migrations table together with the checksum of their up file, so that a migration changed after it has been
+applied is detected.
+*/
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mas2020-golang/goutils/output"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockKey is the key of the PostgreSQL advisory lock held while migrating, so that many replicas starting together
+// don't race
+const lockKey = 6170923418
+
+// fileName is the pattern of the migration files
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedOn *time.Time
+}
+
+// Load returns the embedded migrations ordered by version
+func Load() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migrations from the sql folder of fsys. Every migration must have the up file, the down file is
+// optional.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file '%s' has a wrong name", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(fsys, "sql/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: '%s' and '%s'", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(content)
+			mig.Checksum = fmt.Sprintf("%x", sha256.Sum256(content))
+		} else {
+			mig.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, mig := range byVersion {
+		if len(mig.Up) == 0 {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies the migrations to the database
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New returns a Migrator for the embedded migrations
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool, migrations}, nil
+}
+
+// Up applies all the pending migrations returning how many of them have been applied. Every migration is applied
+// in its own transaction.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			output.InfoLog("", fmt.Sprintf("applying migration %d_%s...", mig.Version, mig.Name))
+			err = conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "INSERT INTO schema_migrations(version, name, checksum) VALUES($1, $2, $3)",
+					mig.Version, mig.Name, mig.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %s", mig.Version, mig.Name, err.Error())
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the last steps applied migrations returning how many of them have been rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if len(mig.Down) == 0 {
+				return fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+			}
+			output.InfoLog("", fmt.Sprintf("rolling back migration %d_%s...", mig.Version, mig.Name))
+			err = conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rollback of migration %d_%s failed: %s", mig.Version, mig.Name, err.Error())
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status returns the state of every migration. It only reads the schema_migrations table, without the advisory
+// lock and without creating the table (every migration is pending if it doesn't exist), so that it can be called
+// often, e.g. by the readiness probe.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx, m.pool)
+	if undefinedTable(err) {
+		applied, err = map[int]time.Time{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if on, ok := applied[mig.Version]; ok {
+			s.Applied, s.AppliedOn = true, &on
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Pending returns the number of migrations not applied yet
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, s := range status {
+		if !s.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// locked calls fn holding the advisory lock on a dedicated connection. The schema_migrations table is created if
+// it doesn't exist.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", int64(lockKey)); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", int64(lockKey))
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    bigint                      NOT NULL,
+    name       text                        NOT NULL,
+    checksum   character varying(64)       NOT NULL,
+    applied_on timestamp without time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (version)
+)`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// querier is the part of a pool or of a connection used to read the applied migrations
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// applied returns the applied migrations with their apply time. An applied migration that has been changed or
+// that doesn't exist anymore is an error.
+func (m *Migrator) applied(ctx context.Context, conn querier) (map[int]time.Time, error) {
+	known := map[int]Migration{}
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version, name, checksum, applied_on FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version        int
+			name, checksum string
+			on             time.Time
+		)
+		if err = rows.Scan(&version, &name, &checksum, &on); err != nil {
+			return nil, err
+		}
+		mig, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but it is unknown", version, name)
+		}
+		if mig.Checksum != checksum {
+			return nil, fmt.Errorf("migration %d_%s has been changed after it was applied (checksum mismatch)",
+				version, name)
+		}
+		applied[version] = on
+	}
+	return applied, rows.Err()
+}
+
+// undefinedTable returns true if err is the PostgreSQL undefined_table error
+func undefinedTable(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "42P01"
+}
@@ -1,15 +1,5 @@
-CREATE TABLE IF NOT EXISTS products
-(
-    id SERIAL,
-    name TEXT NOT NULL,
-    description TEXT NOT NULL,
-    price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-    sku varchar(100),
-    CONSTRAINT products_pkey PRIMARY KEY (id)
-);
-
 /* Table 'users' */
-CREATE TABLE public.users
+CREATE TABLE IF NOT EXISTS public.users
 (
     user_id            serial                      NOT NULL,
     username           character varying(100)      NOT NULL,
@@ -25,3 +15,5 @@ CREATE TABLE public.users
     PRIMARY KEY (user_id)
 );
 
+/* the index is created apart to be added also to the databases created by the old init scripts */
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON public.users (username);
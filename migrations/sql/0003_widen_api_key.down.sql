@@ -0,0 +1,3 @@
+/* it fails if a PHC hash is already stored: reset those passwords first */
+ALTER TABLE public.users
+    ALTER COLUMN api_key TYPE character varying(64);
@@ -0,0 +1,3 @@
+/* the api_key stores the PHC string of the password hash (bcrypt or argon2id), longer than the old SHA-256 */
+ALTER TABLE public.users
+    ALTER COLUMN api_key TYPE character varying(255);
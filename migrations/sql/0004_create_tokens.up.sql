@@ -0,0 +1,22 @@
+/* the expirations are compared with now(), so the time zone is kept to not depend on the session one */
+/* Table 'refresh_tokens': the issued refresh tokens, a token is used only once and the new one has the same family */
+CREATE TABLE IF NOT EXISTS public.refresh_tokens
+(
+    jti        character varying(36)       NOT NULL,
+    family     character varying(36)       NOT NULL,
+    username   character varying(100)      NOT NULL,
+    expires_on timestamp with time zone    NOT NULL,
+    used_on    timestamp with time zone,
+    revoked_on timestamp with time zone,
+    PRIMARY KEY (jti)
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON public.refresh_tokens (family);
+
+/* Table 'revoked_tokens': the ids of the access tokens revoked before their expiration */
+CREATE TABLE IF NOT EXISTS public.revoked_tokens
+(
+    jti        character varying(36)       NOT NULL,
+    expires_on timestamp with time zone    NOT NULL,
+    PRIMARY KEY (jti)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE public.users
+    DROP CONSTRAINT users_user_type_check,
+    ALTER COLUMN user_type DROP DEFAULT;
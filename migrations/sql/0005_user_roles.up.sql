@@ -0,0 +1,4 @@
+/* the user_type is the role of the user (admin, editor or reader) written into the tokens, NULL is a reader */
+ALTER TABLE public.users
+    ALTER COLUMN user_type SET DEFAULT 'reader',
+    ADD CONSTRAINT users_user_type_check CHECK (user_type IN ('admin', 'editor', 'reader'));
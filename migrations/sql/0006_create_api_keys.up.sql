@@ -0,0 +1,19 @@
+/* Table 'api_keys': the long lived credentials of the users, sent in the X-API-Key header. A user can have several
+   keys (e.g. one per batch job), so they are not stored in the api_key columns of users that hold the password hash.
+   Only the sha256 of the key is stored, prefix is its public part used to find it and to show it in the lists. */
+CREATE TABLE IF NOT EXISTS public.api_keys
+(
+    key_id       serial                      NOT NULL,
+    user_id      integer                     NOT NULL REFERENCES public.users (user_id) ON DELETE CASCADE,
+    name         character varying(100)      NOT NULL,
+    prefix       character varying(16)       NOT NULL,
+    hash         character varying(64)       NOT NULL,
+    created      timestamp with time zone    NOT NULL,
+    expires_on   timestamp with time zone,
+    last_used_on timestamp with time zone,
+    revoked_on   timestamp with time zone,
+    PRIMARY KEY (key_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_prefix_key ON public.api_keys (prefix);
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON public.api_keys (user_id);
@@ -0,0 +1,5 @@
+/* failed_logins counts the consecutive failed logins of the user, the logins are refused until locked_until: it is
+   moved forward at every failure (exponential backoff) and by the lockout time when too many logins failed */
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS failed_logins integer DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS locked_until  timestamp with time zone;
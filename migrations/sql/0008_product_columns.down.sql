@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS products_fts_idx;
+ALTER TABLE products
+    DROP COLUMN IF EXISTS version,
+    DROP COLUMN IF EXISTS deleted_on,
+    DROP COLUMN IF EXISTS updated_on,
+    DROP COLUMN IF EXISTS created_on;
@@ -0,0 +1,10 @@
+/* the columns and the index are added if missing, also to the databases created by the old init scripts */
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS created_on timestamp without time zone NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updated_on timestamp without time zone,
+    ADD COLUMN IF NOT EXISTS deleted_on timestamp without time zone,
+    ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+/* full text search over name and description (GET /products?q=) */
+CREATE INDEX IF NOT EXISTS products_fts_idx ON products
+    USING GIN (to_tsvector('english', name || ' ' || description));
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// custom errors
+var (
+	ErrApiKeyNotFound = fmt.Errorf("api key not found")
+)
+
+// ApiKey is a long lived credential of a user, sent in the X-API-Key header. Only the Hash of the key is stored,
+// the key itself is returned once when it is created or rotated. Prefix is the public part of the key, used to
+// find it and to recognize it in the lists.
+type ApiKey struct {
+	ID         int        `json:"key-id"`
+	UserID     int        `json:"user-id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Created    time.Time  `json:"created"`
+	ExpiresOn  *time.Time `json:"expires-on,omitempty"`
+	LastUsedOn *time.Time `json:"last-used-on,omitempty"`
+	RevokedOn  *time.Time `json:"revoked-on,omitempty"`
+}
+
+// Valid returns true if the key is not revoked nor expired
+func (k *ApiKey) Valid(now time.Time) bool {
+	return k.RevokedOn == nil && (k.ExpiresOn == nil || k.ExpiresOn.After(now))
+}
+
+// ApiKeysT is a list of api keys
+type ApiKeysT []*ApiKey
+
+func (p *ApiKeysT) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// ApiKeyRepository is the storage of the api keys. PgApiKeys is the implementation backed by PostgreSQL, MemApiKeys
+// keeps everything in memory.
+type ApiKeyRepository interface {
+	// GetAll returns the keys of the user, the revoked and expired ones included
+	GetAll(ctx context.Context, userID int) (ApiKeysT, error)
+	// Get returns the key of the user with the given id, ErrApiKeyNotFound if it doesn't exist
+	Get(ctx context.Context, userID, id int) (*ApiKey, error)
+	// GetByPrefix returns the key with the given prefix, ErrApiKeyNotFound if it doesn't exist
+	GetByPrefix(ctx context.Context, prefix string) (*ApiKey, error)
+	// Add stores a new key setting its ID and Created
+	Add(ctx context.Context, key *ApiKey) error
+	// Rotate replaces prefix, hash and expiration of the key (the old key stops working), Created is set to now
+	Rotate(ctx context.Context, key *ApiKey) error
+	// Revoke revokes the key of the user, ErrApiKeyNotFound if it doesn't exist or it is already revoked
+	Revoke(ctx context.Context, userID, id int) error
+	// Touch sets the last time the key has been used
+	Touch(ctx context.Context, id int) error
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemApiKeys is an ApiKeyRepository that keeps the keys in memory. It is safe for concurrent use and it is mainly
+// intended for the tests, where a running PostgreSQL is not available.
+type MemApiKeys struct {
+	mu     sync.RWMutex
+	keys   map[int]*ApiKey
+	nextID int
+}
+
+// NewMemApiKeys returns an empty in memory ApiKeyRepository
+func NewMemApiKeys() *MemApiKeys {
+	return &MemApiKeys{keys: make(map[int]*ApiKey), nextID: 1}
+}
+
+// GetAll returns the keys of the user
+func (m *MemApiKeys) GetAll(ctx context.Context, userID int) (ApiKeysT, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := ApiKeysT{}
+	for _, key := range m.keys {
+		if key.UserID == userID {
+			c := *key
+			keys = append(keys, &c)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// Get returns the key of the user with the given id
+func (m *MemApiKeys) Get(ctx context.Context, userID, id int) (*ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[id]
+	if !ok || key.UserID != userID {
+		return nil, ErrApiKeyNotFound
+	}
+	c := *key
+	return &c, nil
+}
+
+// GetByPrefix returns the key with the given prefix
+func (m *MemApiKeys) GetByPrefix(ctx context.Context, prefix string) (*ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.Prefix == prefix {
+			c := *key
+			return &c, nil
+		}
+	}
+	return nil, ErrApiKeyNotFound
+}
+
+// Add stores a new key
+func (m *MemApiKeys) Add(ctx context.Context, key *ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = m.nextID
+	key.Created = time.Now()
+	m.nextID++
+	c := *key
+	m.keys[key.ID] = &c
+	return nil
+}
+
+// Rotate replaces prefix, hash and expiration of a key not revoked
+func (m *MemApiKeys) Rotate(ctx context.Context, key *ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.keys[key.ID]
+	if !ok || stored.UserID != key.UserID || stored.RevokedOn != nil {
+		return ErrApiKeyNotFound
+	}
+	key.Created = time.Now()
+	stored.Prefix, stored.Hash, stored.ExpiresOn = key.Prefix, key.Hash, key.ExpiresOn
+	stored.Created, stored.LastUsedOn = key.Created, nil
+	return nil
+}
+
+// Revoke revokes the key of the user
+func (m *MemApiKeys) Revoke(ctx context.Context, userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[id]
+	if !ok || key.UserID != userID || key.RevokedOn != nil {
+		return ErrApiKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedOn = &now
+	return nil
+}
+
+// Touch sets the last time the key has been used
+func (m *MemApiKeys) Touch(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.keys[id]; ok {
+		now := time.Now()
+		key.LastUsedOn = &now
+	}
+	return nil
+}
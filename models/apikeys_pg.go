@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+)
+
+// apiKeyColumns are the columns read by scanApiKey
+const apiKeyColumns = "key_id, user_id, name, prefix, hash, created, expires_on, last_used_on, revoked_on"
+
+// PgApiKeys is the ApiKeyRepository that reads and writes the api_keys table on PostgreSQL
+type PgApiKeys struct {
+	db DB
+}
+
+// NewPgApiKeys returns an ApiKeyRepository that uses the given database
+func NewPgApiKeys(db DB) *PgApiKeys {
+	return &PgApiKeys{db}
+}
+
+// GetAll returns the keys of the user
+func (p *PgApiKeys) GetAll(ctx context.Context, userID int) (ApiKeysT, error) {
+	rows, err := p.db.Query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY key_id",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := ApiKeysT{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns the key of the user with the given id
+func (p *PgApiKeys) Get(ctx context.Context, userID, id int) (*ApiKey, error) {
+	return scanApiKey(p.db.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 AND key_id = $2",
+		userID, id))
+}
+
+// GetByPrefix returns the key with the given prefix
+func (p *PgApiKeys) GetByPrefix(ctx context.Context, prefix string) (*ApiKey, error) {
+	return scanApiKey(p.db.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix))
+}
+
+// Add stores a new key
+func (p *PgApiKeys) Add(ctx context.Context, key *ApiKey) error {
+	return p.db.QueryRow(ctx, `INSERT INTO api_keys(user_id, name, prefix, hash, created, expires_on)
+		VALUES($1, $2, $3, $4, now(), $5) RETURNING key_id, created`,
+		key.UserID, key.Name, key.Prefix, key.Hash, key.ExpiresOn).Scan(&key.ID, &key.Created)
+}
+
+// Rotate replaces prefix, hash and expiration of a key not revoked
+func (p *PgApiKeys) Rotate(ctx context.Context, key *ApiKey) error {
+	err := p.db.QueryRow(ctx, `UPDATE api_keys SET prefix = $3, hash = $4, expires_on = $5, created = now(),
+		last_used_on = NULL WHERE user_id = $1 AND key_id = $2 AND revoked_on IS NULL RETURNING created`,
+		key.UserID, key.ID, key.Prefix, key.Hash, key.ExpiresOn).Scan(&key.Created)
+	if err == pgx.ErrNoRows {
+		return ErrApiKeyNotFound
+	}
+	return err
+}
+
+// Revoke revokes the key of the user
+func (p *PgApiKeys) Revoke(ctx context.Context, userID, id int) error {
+	tag, err := p.db.Exec(ctx, `UPDATE api_keys SET revoked_on = now()
+		WHERE user_id = $1 AND key_id = $2 AND revoked_on IS NULL`, userID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrApiKeyNotFound
+	}
+	return nil
+}
+
+// Touch sets the last time the key has been used
+func (p *PgApiKeys) Touch(ctx context.Context, id int) error {
+	_, err := p.db.Exec(ctx, "UPDATE api_keys SET last_used_on = now() WHERE key_id = $1", id)
+	return err
+}
+
+// scanApiKey reads a row with the apiKeyColumns
+func scanApiKey(row pgx.Row) (*ApiKey, error) {
+	key := &ApiKey{}
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.Created, &key.ExpiresOn,
+		&key.LastUsedOn, &key.RevokedOn)
+	if err == pgx.ErrNoRows {
+		return nil, ErrApiKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
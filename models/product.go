@@ -8,44 +8,34 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/go-playground/validator"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mas2020-golang/rest-api/validation"
 	"io"
-	"regexp"
+	"time"
 )
 
 // Product defines the structure for an API product
 type Product struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description"`
-	Price       float32 `json:"price" validate:"gt=0"`
-	SKU         string  `json:"sku" validate:"required,sku"`
-	CreatedOn   string  `json:"-"`
-	UpdatedOn   string  `json:"-"`
-	DeletedOn   string  `json:"-"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name" validate:"required,short_text"`
+	Description string     `json:"description" validate:"long_text"`
+	Price       float32    `json:"price" validate:"gt=0"`
+	SKU         string     `json:"sku" validate:"required,sku"`
+	CreatedOn   *time.Time `json:"-"`
+	UpdatedOn   *time.Time `json:"-"`
+	DeletedOn   *time.Time `json:"deleted_on,omitempty"`
+	// Version is incremented on every change, it is exposed as ETag
+	Version int `json:"-"`
 }
 
 // custom errors
 var (
-	RecordNotFound = fmt.Errorf("product not found")
-	Products ProductsT = ProductsT{}
+	RecordNotFound     = fmt.Errorf("product not found")
+	ErrVersionMismatch = fmt.Errorf("product has been modified in the meantime")
 )
 
-// Validate the structure
+// Validate the structure, the sku is made of three groups of lowercase letters (e.g. abc-def-ghi)
 func (p *Product) Validate() error {
-	validate := validator.New()
-	// this methods is used to validate in a custom way the field SKU
-	validate.RegisterValidation("sku", validateSKU)
-	return validate.Struct(p)
-}
-
-// validateSKU is a custom validation func for the SKU field
-func validateSKU(fl validator.FieldLevel) bool {
-	// sku is of sada-ads-adas
-	re := regexp.MustCompile(`[a-z]+-[a-z]+-[a-z]+`)
-	result := re.FindAllString(fl.Field().String(), -1)
-	return len(result) == 1
+	return validation.Struct(p)
 }
 
 // FromJSON fills Product decoding the JSON read from the reader.
@@ -68,71 +58,36 @@ func (p *ProductsT) ToJSON() ([]byte, error) {
 	return json.Marshal(p)
 }
 
-// GetAll returns a slice of *Product.
-func (p *ProductsT) GetAll(pool *pgxpool.Pool) (ProductsT, error) {
-	var productList ProductsT
-	rows, err := pool.Query(context.Background(), "SELECT id, name, description, price, sku FROM products")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	/*
-	    id SERIAL,
-	    name TEXT NOT NULL,
-	    description TEXT NOT NULL,
-	    price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-	    sku varchar(100),
-	 */
-	// iterate through the result set
-	for rows.Next() {
-		p := Product{}
-		err = rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.SKU)
-		if err != nil {
-			return nil, err
-		}
-		productList = append(productList, &p)
-	}
-
-	// Any errors encountered by rows.Next or rows.Scan will be returned here
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
-	// return the list of products
-	return productList, nil
-}
-
-// Get the product reading db. All the fields are stored in the *Product object returned by the method.
-func (p *ProductsT) Get(pool *pgxpool.Pool, id int) (prod *Product, err error) {
-	row := pool.QueryRow(context.Background(), "SELECT id, name, description, price, sku FROM products WHERE id=$1",
-		id)
-	prod = new(Product)
-	err = row.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.SKU)
-	return prod, err
+// patchColumns are the columns that can be written by a patch
+var patchColumns = map[string]bool{
+	"name":        true,
+	"description": true,
+	"price":       true,
+	"sku":         true,
 }
 
-// Add a new product to the products table
-func (p *ProductsT) Add(pool *pgxpool.Pool, new *Product) error {
-	err := pool.QueryRow(context.Background(),
-		"INSERT INTO products(name, price, description, sku) VALUES($1, $2, $3, $4) RETURNING id",
-		(*new).Name, (*new).Price, (*new).Description, (*new).SKU).Scan(&new.ID)
-
-	if err != nil {
-		return err
-	}
-	return nil
+// ProductRepository is the storage used to read and write the products. PgProducts is the implementation backed by
+// PostgreSQL, MemProducts keeps everything in memory.
+type ProductRepository interface {
+	// GetAll returns the page of products selected by the query, ordered by id
+	GetAll(ctx context.Context, q ProductQuery) (*ProductPage, error)
+	// Get returns the product with the given id or RecordNotFound
+	Get(ctx context.Context, id int, includeDeleted bool) (*Product, error)
+	// Add stores a new product setting its ID
+	Add(ctx context.Context, prod *Product) error
+	// Update replaces the product with the same ID or returns RecordNotFound. If prod.Version is set and the stored
+	// product has a different version ErrVersionMismatch is returned. prod.Version is set to the new version.
+	Update(ctx context.Context, prod *Product) error
+	// Patch writes only the given columns of the product, RecordNotFound and ErrVersionMismatch are returned as
+	// in Update
+	Patch(ctx context.Context, prod *Product, columns []string) error
+	// AddAll stores all the products in a single transaction: either all of them are stored or none
+	AddAll(ctx context.Context, products ProductsT) error
+	// Walk calls fn for every product ordered by id without loading all of them in memory, it stops at the first
+	// error returned by fn
+	Walk(ctx context.Context, includeDeleted bool, fn func(prod *Product) error) error
+	// Delete marks the product as deleted or returns RecordNotFound
+	Delete(ctx context.Context, id int) error
+	// Restore brings back a deleted product or returns RecordNotFound
+	Restore(ctx context.Context, id int) error
 }
-
-// Update the product in the collection
-func (p *ProductsT) Update(pool *pgxpool.Pool, prod *Product) error {
-	tag, err := pool.Exec(context.Background(), "UPDATE products SET name = $1, price = $2, " +
-		"description = $3, sku = $4 WHERE id = $5",
-		prod.Name, prod.Price, prod.Description, prod.SKU, prod.ID)
-	if err != nil {
-		return err
-	}
-	if tag.RowsAffected() != 1 {
-		return RecordNotFound
-	}
-	return nil
-}
\ No newline at end of file
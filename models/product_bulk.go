@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// media types accepted to import and export the products
+const (
+	CSVType    = "text/csv"
+	NDJSONType = "application/x-ndjson"
+)
+
+// ErrBulkMediaType is returned for a media type that can't be used to import or export the products
+var ErrBulkMediaType = fmt.Errorf("media type not supported, use %s or %s", CSVType, NDJSONType)
+
+// csvColumns are the columns of the CSV format, the header row is mandatory
+var csvColumns = []string{"name", "description", "price", "sku"}
+
+// DecodeProducts reads the products one by one from r calling fn for every row. A row that can't be decoded is
+// passed to fn with the error and the reading goes on, while an error returned by fn stops the reading. Rows are
+// counted from 1 and the CSV header is not counted.
+func DecodeProducts(mediaType string, r io.Reader, fn func(row int, prod *Product, err error) error) error {
+	switch mediaType {
+	case CSVType:
+		return decodeCSV(r, fn)
+	case NDJSONType:
+		return decodeNDJSON(r, fn)
+	}
+	return ErrBulkMediaType
+}
+
+// decodeCSV reads the CSV rows, the columns are matched using the header row. The id column written by the export
+// is accepted and ignored.
+func decodeCSV(r io.Reader, fn func(row int, prod *Product, err error) error) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("CSV header row can't be read: %s", err.Error())
+	}
+	index := map[string]int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "id" {
+			continue
+		}
+		found := false
+		for _, c := range csvColumns {
+			found = found || c == column
+		}
+		if !found {
+			return fmt.Errorf("unknown CSV column '%s', the allowed columns are %s", column,
+				strings.Join(csvColumns, ","))
+		}
+		index[column] = i
+	}
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return err
+			}
+			if err = fn(row, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		prod := &Product{}
+		value := func(column string) string {
+			if i, ok := index[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		prod.Name, prod.Description, prod.SKU = value("name"), value("description"), value("sku")
+		if v := value("price"); len(v) > 0 {
+			price, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				if err = fn(row, nil, fmt.Errorf("price '%s' is not a number", v)); err != nil {
+					return err
+				}
+				continue
+			}
+			prod.Price = float32(price)
+		}
+		if err = fn(row, prod, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeNDJSON reads a product for every line, the empty lines are skipped
+func decodeNDJSON(r io.Reader, fn func(row int, prod *Product, err error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			row--
+			continue
+		}
+		prod := &Product{}
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.DisallowUnknownFields()
+		err := d.Decode(prod)
+		if err == nil {
+			// only the fields that can be written are accepted
+			prod.ID, prod.DeletedOn = 0, nil
+		} else {
+			prod = nil
+		}
+		if err = fn(row, prod, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ProductEncoder writes the products one by one in a bulk format, call Flush at the end
+type ProductEncoder interface {
+	Encode(prod *Product) error
+	Flush() error
+}
+
+// NewProductEncoder returns the encoder writing the products to w using the media type
+func NewProductEncoder(mediaType string, w io.Writer) (ProductEncoder, error) {
+	switch mediaType {
+	case CSVType:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	case NDJSONType:
+		return &ndjsonEncoder{json.NewEncoder(w)}, nil
+	}
+	return nil, ErrBulkMediaType
+}
+
+// csvEncoder writes the products as CSV rows, the header row is written before the first product
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(prod *Product) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.w.Write([]string{strconv.Itoa(prod.ID), prod.Name, prod.Description,
+		strconv.FormatFloat(float64(prod.Price), 'f', -1, 32), prod.SKU})
+}
+
+// Flush writes the buffered rows, the header row is written even if there are no products
+func (e *csvEncoder) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// writeHeader writes the header row only the first time it is called
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write(append([]string{"id"}, csvColumns...))
+}
+
+// ndjsonEncoder writes a product as JSON on every line
+type ndjsonEncoder struct {
+	e *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(prod *Product) error {
+	return e.e.Encode(prod)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return nil
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemProducts is a ProductRepository that keeps the products in memory. It is safe for concurrent use and it is
+// mainly intended for the tests, where a running PostgreSQL is not available.
+type MemProducts struct {
+	mu       sync.RWMutex
+	products map[int]*Product
+	lastID   int
+}
+
+// NewMemProducts returns an empty in memory ProductRepository
+func NewMemProducts() *MemProducts {
+	return &MemProducts{products: make(map[int]*Product)}
+}
+
+// GetAll returns a page of products. Soft deleted products are skipped unless q.IncludeDeleted is true. The full
+// text search is approximated: every word of q.Search has to be found into the name or the description.
+func (m *MemProducts) GetAll(ctx context.Context, q ProductQuery) (*ProductPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var productList ProductsT
+	for _, prod := range m.products {
+		if q.match(prod) {
+			productList = append(productList, prod)
+		}
+	}
+	fields := q.sortFields()
+	sort.Slice(productList, func(i, j int) bool {
+		return compareProducts(fields, productList[i].columnValue, productList[j].columnValue) < 0
+	})
+	total := len(productList)
+
+	// keyset pagination: skip everything up to the cursor
+	if q.After != nil {
+		after := func(column string) interface{} {
+			for i, f := range fields {
+				if f.Column == column {
+					return q.After.Values[i]
+				}
+			}
+			return nil
+		}
+		start := sort.Search(len(productList), func(i int) bool {
+			return compareProducts(fields, productList[i].columnValue, after) > 0
+		})
+		productList = productList[start:]
+	}
+	if q.Offset >= len(productList) {
+		productList = nil
+	} else {
+		productList = productList[q.Offset:]
+	}
+	if len(productList) > q.limit()+1 {
+		productList = productList[:q.limit()+1]
+	}
+
+	var page ProductsT
+	for _, prod := range productList {
+		page = append(page, copyProduct(prod))
+	}
+	return newProductPage(q, page, total), nil
+}
+
+// Get returns a copy of the stored product. A soft deleted product is returned only if includeDeleted is true,
+// otherwise RecordNotFound is returned.
+func (m *MemProducts) Get(ctx context.Context, id int, includeDeleted bool) (*Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prod, ok := m.products[id]
+	if !ok || (prod.DeletedOn != nil && !includeDeleted) {
+		return nil, RecordNotFound
+	}
+	return copyProduct(prod), nil
+}
+
+// Add a new product to the store assigning the next available id
+func (m *MemProducts) Add(ctx context.Context, new *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.add(new)
+	return nil
+}
+
+// add stores the product, the caller must hold the lock
+func (m *MemProducts) add(new *Product) {
+	m.lastID++
+	new.ID = m.lastID
+	now := time.Now()
+	new.CreatedOn = &now
+	new.Version = 1
+	m.products[new.ID] = copyProduct(new)
+}
+
+// AddAll stores all the products assigning them the next available ids
+func (m *MemProducts) AddAll(ctx context.Context, products ProductsT) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, prod := range products {
+		m.add(prod)
+	}
+	return nil
+}
+
+// Walk calls fn for every product ordered by id. fn is called on a snapshot of the store, so it can use the
+// repository as well.
+func (m *MemProducts) Walk(ctx context.Context, includeDeleted bool, fn func(prod *Product) error) error {
+	m.mu.RLock()
+	var productList ProductsT
+	for _, prod := range m.products {
+		if prod.DeletedOn == nil || includeDeleted {
+			productList = append(productList, copyProduct(prod))
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(productList, func(i, j int) bool { return productList[i].ID < productList[j].ID })
+	for _, prod := range productList {
+		if err := fn(prod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update the product in the store. Soft deleted products can't be updated until they are restored. If
+// prod.Version is set the product is updated only if it still has that version.
+func (m *MemProducts) Update(ctx context.Context, prod *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.updatable(prod)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	stored.Name, stored.Price, stored.Description, stored.SKU = prod.Name, prod.Price, prod.Description, prod.SKU
+	stored.UpdatedOn = &now
+	stored.Version++
+	prod.Version = stored.Version
+	return nil
+}
+
+// Patch writes only the given columns of the product. Soft deleted products can't be patched. The version is
+// checked and updated as in Update.
+func (m *MemProducts) Patch(ctx context.Context, prod *Product, columns []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.updatable(prod)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if !patchColumns[column] {
+			return fmt.Errorf("column '%s' can't be patched", column)
+		}
+	}
+	for _, column := range columns {
+		switch column {
+		case "name":
+			stored.Name = prod.Name
+		case "description":
+			stored.Description = prod.Description
+		case "price":
+			stored.Price = prod.Price
+		case "sku":
+			stored.SKU = prod.SKU
+		}
+	}
+	now := time.Now()
+	stored.UpdatedOn = &now
+	stored.Version++
+	prod.Version = stored.Version
+	return nil
+}
+
+// updatable returns the stored product to update, RecordNotFound if it doesn't exist or ErrVersionMismatch if the
+// version of prod is set and it is not the stored one
+func (m *MemProducts) updatable(prod *Product) (*Product, error) {
+	stored, ok := m.products[prod.ID]
+	switch {
+	case !ok || stored.DeletedOn != nil:
+		return nil, RecordNotFound
+	case prod.Version != 0 && prod.Version != stored.Version:
+		return nil, ErrVersionMismatch
+	}
+	return stored, nil
+}
+
+// Delete marks the product as deleted
+func (m *MemProducts) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.products[id]
+	if !ok || stored.DeletedOn != nil {
+		return RecordNotFound
+	}
+	now := time.Now()
+	stored.DeletedOn = &now
+	stored.Version++
+	return nil
+}
+
+// Restore brings back a soft deleted product
+func (m *MemProducts) Restore(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.products[id]
+	if !ok || stored.DeletedOn == nil {
+		return RecordNotFound
+	}
+	now := time.Now()
+	stored.DeletedOn = nil
+	stored.UpdatedOn = &now
+	stored.Version++
+	return nil
+}
+
+// Reset removes all the products and restarts the id sequence
+func (m *MemProducts) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.products = make(map[int]*Product)
+	m.lastID = 0
+}
+
+// match returns true if the product satisfies all the filters of the query
+func (q ProductQuery) match(prod *Product) bool {
+	switch {
+	case prod.DeletedOn != nil && !q.IncludeDeleted,
+		q.PriceMin != nil && prod.Price < *q.PriceMin,
+		q.PriceMax != nil && prod.Price > *q.PriceMax,
+		len(q.SKU) > 0 && prod.SKU != q.SKU,
+		len(q.SKUPrefix) > 0 && !strings.HasPrefix(prod.SKU, q.SKUPrefix),
+		len(q.Name) > 0 && !strings.Contains(strings.ToLower(prod.Name), strings.ToLower(q.Name)):
+		return false
+	}
+	text := strings.ToLower(prod.Name + " " + prod.Description)
+	for _, word := range strings.Fields(strings.ToLower(q.Search)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareProducts compares the sort values of two products returning -1, 0 or +1 according to the sort fields
+func compareProducts(fields []SortField, a, b func(column string) interface{}) int {
+	for _, f := range fields {
+		c := 0
+		switch va := a(f.Column).(type) {
+		case int:
+			vb := b(f.Column).(int)
+			if va < vb {
+				c = -1
+			} else if va > vb {
+				c = 1
+			}
+		case float32:
+			vb := b(f.Column).(float32)
+			if va < vb {
+				c = -1
+			} else if va > vb {
+				c = 1
+			}
+		case string:
+			c = strings.Compare(va, b(f.Column).(string))
+		}
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// copyProduct returns a copy of the product so that the callers never share memory with the store
+func copyProduct(prod *Product) *Product {
+	c := *prod
+	return &c
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// media types accepted to patch a product
+const (
+	MergePatchType = "application/merge-patch+json" // RFC 7396
+	JSONPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// patch errors
+var (
+	ErrPatchMediaType  = fmt.Errorf("patch media type not supported, use %s or %s", MergePatchType, JSONPatchType)
+	ErrPatchTestFailed = fmt.Errorf("patch test operation failed")
+)
+
+// ApplyPatch applies the patch to a copy of the product. It returns the patched product and the columns that have
+// been changed by the patch. The patched product is not validated, call Validate on it before storing it.
+func (p *Product) ApplyPatch(mediaType string, patch []byte) (*Product, []string, error) {
+	doc, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch mediaType {
+	case MergePatchType:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	case JSONPatchType:
+		var ops jsonpatch.Patch
+		ops, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			doc, err = ops.Apply(doc)
+		}
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, nil, ErrPatchTestFailed
+		}
+	default:
+		return nil, nil, ErrPatchMediaType
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("patch can't be applied: %s", err.Error())
+	}
+
+	patched := &Product{}
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.DisallowUnknownFields()
+	if err = d.Decode(patched); err != nil {
+		return nil, nil, fmt.Errorf("patched product is not valid: %s", err.Error())
+	}
+	if patched.ID != p.ID || patched.DeletedOn != nil {
+		return nil, nil, fmt.Errorf("only name, description, price and sku can be patched")
+	}
+	patched.CreatedOn, patched.UpdatedOn, patched.Version = p.CreatedOn, p.UpdatedOn, p.Version
+
+	var columns []string
+	if patched.Name != p.Name {
+		columns = append(columns, "name")
+	}
+	if patched.Description != p.Description {
+		columns = append(columns, "description")
+	}
+	if patched.Price != p.Price {
+		columns = append(columns, "price")
+	}
+	if patched.SKU != p.SKU {
+		columns = append(columns, "sku")
+	}
+	return patched, columns, nil
+}
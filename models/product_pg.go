@@ -0,0 +1,301 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"strconv"
+	"strings"
+)
+
+// PgProducts is the ProductRepository that reads and writes the products table on PostgreSQL
+type PgProducts struct {
+	db DB
+}
+
+// NewPgProducts returns a ProductRepository that uses the given database
+func NewPgProducts(db DB) *PgProducts {
+	return &PgProducts{db}
+}
+
+// GetAll returns a page of products. Soft deleted products are skipped unless q.IncludeDeleted is true. The total
+// count is the number of products matching the filters regardless of the page read.
+func (p *PgProducts) GetAll(ctx context.Context, q ProductQuery) (*ProductPage, error) {
+	var (
+		conditions []string
+		args       []interface{}
+		total      int
+	)
+	// arg adds a query parameter returning its placeholder. The prices are sent as decimal strings: pgx would send
+	// a float32 as its exact value (e.g. 19.99 as 19.989999771118164) that doesn't compare equal to NUMERIC(10,2).
+	arg := func(v interface{}) string {
+		if price, ok := v.(float32); ok {
+			args = append(args, strconv.FormatFloat(float64(price), 'f', 2, 32))
+			return fmt.Sprintf("$%d::numeric", len(args))
+		}
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// filters
+	if !q.IncludeDeleted {
+		conditions = append(conditions, "deleted_on IS NULL")
+	}
+	if q.PriceMin != nil {
+		conditions = append(conditions, "price >= "+arg(*q.PriceMin))
+	}
+	if q.PriceMax != nil {
+		conditions = append(conditions, "price <= "+arg(*q.PriceMax))
+	}
+	if len(q.SKU) > 0 {
+		conditions = append(conditions, "sku = "+arg(q.SKU))
+	}
+	if len(q.SKUPrefix) > 0 {
+		conditions = append(conditions, "sku LIKE "+arg(escapeLike(q.SKUPrefix)+"%"))
+	}
+	if len(q.Name) > 0 {
+		conditions = append(conditions, "name ILIKE "+arg("%"+escapeLike(q.Name)+"%"))
+	}
+	if len(q.Search) > 0 {
+		conditions = append(conditions, "to_tsvector('english', name || ' ' || description) @@ "+
+			"plainto_tsquery('english', "+arg(q.Search)+")")
+	}
+	err := p.db.QueryRow(ctx, "SELECT count(*) FROM products"+whereClause(conditions), args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// keyset pagination: read only after the cursor, in the sort order
+	fields := q.sortFields()
+	if q.After != nil {
+		var or []string
+		for i, f := range fields {
+			var and []string
+			for j := 0; j < i; j++ {
+				and = append(and, fields[j].Column+" = "+arg(q.After.Values[j]))
+			}
+			op := " > "
+			if f.Desc {
+				op = " < "
+			}
+			and = append(and, f.Column+op+arg(q.After.Values[i]))
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		}
+		conditions = append(conditions, "("+strings.Join(or, " OR ")+")")
+	}
+	var orderBy []string
+	for _, f := range fields {
+		if f.Desc {
+			orderBy = append(orderBy, f.Column+" DESC")
+		} else {
+			orderBy = append(orderBy, f.Column)
+		}
+	}
+	query := fmt.Sprintf("SELECT id, name, description, price, sku, deleted_on, version FROM products%s "+
+		"ORDER BY %s LIMIT %s OFFSET %s", whereClause(conditions), strings.Join(orderBy, ", "), arg(q.limit()+1),
+		arg(q.Offset))
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	/*
+	   id SERIAL,
+	   name TEXT NOT NULL,
+	   description TEXT NOT NULL,
+	   price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
+	   sku varchar(100),
+	   deleted_on timestamp,
+	   version integer,
+	*/
+	// iterate through the result set
+	var productList ProductsT
+	for rows.Next() {
+		prod := Product{}
+		err = rows.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.SKU, &prod.DeletedOn,
+			&prod.Version)
+		if err != nil {
+			return nil, err
+		}
+		productList = append(productList, &prod)
+	}
+
+	// Any errors encountered by rows.Next or rows.Scan will be returned here
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	// return the page of products
+	return newProductPage(q, productList, total), nil
+}
+
+// Get the product reading db. All the fields are stored in the *Product object returned by the method.
+// A soft deleted product is returned only if includeDeleted is true, otherwise RecordNotFound is returned.
+func (p *PgProducts) Get(ctx context.Context, id int, includeDeleted bool) (prod *Product, err error) {
+	query := "SELECT id, name, description, price, sku, deleted_on, version FROM products WHERE id=$1"
+	if !includeDeleted {
+		query += " AND deleted_on IS NULL"
+	}
+	prod = new(Product)
+	err = p.db.QueryRow(ctx, query, id).Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price,
+		&prod.SKU, &prod.DeletedOn, &prod.Version)
+	if err == pgx.ErrNoRows {
+		return nil, RecordNotFound
+	}
+	return prod, err
+}
+
+// Add a new product to the products table
+func (p *PgProducts) Add(ctx context.Context, new *Product) error {
+	err := p.db.QueryRow(ctx,
+		"INSERT INTO products(name, price, description, sku) VALUES($1, $2, $3, $4) RETURNING id, version",
+		(*new).Name, (*new).Price, (*new).Description, (*new).SKU).Scan(&new.ID, &new.Version)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddAll stores all the products using the COPY protocol in a single transaction. The ids of the new products are
+// not set.
+func (p *PgProducts) AddAll(ctx context.Context, products ProductsT) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// rollback is a no-op after the commit
+	defer tx.Rollback(ctx)
+
+	rows := make([][]interface{}, 0, len(products))
+	for _, prod := range products {
+		rows = append(rows, []interface{}{prod.Name, prod.Description, prod.Price, prod.SKU})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"products"}, []string{"name", "description", "price", "sku"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Walk reads the products ordered by id calling fn for every row, the rows are read from the database one at a time
+func (p *PgProducts) Walk(ctx context.Context, includeDeleted bool, fn func(prod *Product) error) error {
+	query := "SELECT id, name, description, price, sku, deleted_on, version FROM products"
+	if !includeDeleted {
+		query += " WHERE deleted_on IS NULL"
+	}
+	rows, err := p.db.Query(ctx, query+" ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		prod := Product{}
+		err = rows.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.SKU, &prod.DeletedOn,
+			&prod.Version)
+		if err != nil {
+			return err
+		}
+		if err = fn(&prod); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Update the product in the collection. Soft deleted products can't be updated until they are restored. If
+// prod.Version is set the row is updated only if it still has that version, otherwise ErrVersionMismatch is
+// returned. prod.Version is set to the new version.
+func (p *PgProducts) Update(ctx context.Context, prod *Product) error {
+	err := p.db.QueryRow(ctx, "UPDATE products SET name = $1, price = $2, "+
+		"description = $3, sku = $4, updated_on = now(), version = version + 1 WHERE id = $5 "+
+		"AND deleted_on IS NULL AND ($6 = 0 OR version = $6) RETURNING version",
+		prod.Name, prod.Price, prod.Description, prod.SKU, prod.ID, prod.Version).Scan(&prod.Version)
+	if err == pgx.ErrNoRows {
+		return p.notUpdated(ctx, prod.ID)
+	}
+	return err
+}
+
+// Patch writes only the given columns of the product. Soft deleted products can't be patched. The version is
+// checked and updated as in Update.
+func (p *PgProducts) Patch(ctx context.Context, prod *Product, columns []string) error {
+	var (
+		set  []string
+		args []interface{}
+	)
+	for _, column := range columns {
+		if !patchColumns[column] {
+			return fmt.Errorf("column '%s' can't be patched", column)
+		}
+		args = append(args, prod.columnValue(column))
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	set = append(set, "updated_on = now()", "version = version + 1")
+	args = append(args, prod.ID, prod.Version)
+	err := p.db.QueryRow(ctx, fmt.Sprintf("UPDATE products SET %s WHERE id = $%d AND deleted_on IS NULL "+
+		"AND ($%d = 0 OR version = $%d) RETURNING version", strings.Join(set, ", "), len(args)-1, len(args),
+		len(args)), args...).Scan(&prod.Version)
+	if err == pgx.ErrNoRows {
+		return p.notUpdated(ctx, prod.ID)
+	}
+	return err
+}
+
+// notUpdated tells why a product has not been updated: ErrVersionMismatch if the product exists (so the version
+// was changed in the meantime), RecordNotFound otherwise
+func (p *PgProducts) notUpdated(ctx context.Context, id int) error {
+	var exists bool
+	err := p.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND deleted_on IS NULL)",
+		id).Scan(&exists)
+	switch {
+	case err != nil:
+		return err
+	case exists:
+		return ErrVersionMismatch
+	}
+	return RecordNotFound
+}
+
+// Delete marks the product as deleted setting the deleted_on column. The row is kept in the table and can be
+// brought back using Restore.
+func (p *PgProducts) Delete(ctx context.Context, id int) error {
+	tag, err := p.db.Exec(ctx,
+		"UPDATE products SET deleted_on = now(), version = version + 1 WHERE id = $1 AND deleted_on IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return RecordNotFound
+	}
+	return nil
+}
+
+// Restore brings back a soft deleted product clearing the deleted_on column
+func (p *PgProducts) Restore(ctx context.Context, id int) error {
+	tag, err := p.db.Exec(ctx,
+		"UPDATE products SET deleted_on = NULL, updated_on = now(), version = version + 1 WHERE id = $1 "+
+			"AND deleted_on IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return RecordNotFound
+	}
+	return nil
+}
+
+// whereClause joins the conditions in a WHERE clause, it returns an empty string if there are no conditions
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// escapeLike escapes the LIKE wildcards so that the value is searched as it is
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the page size used when the caller doesn't ask for a specific one
+	DefaultLimit = 50
+	// MaxLimit is the biggest page size a caller can ask for
+	MaxLimit = 500
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded or it has been created for a different sort
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
+// sortColumns is the whitelist of the columns the products can be sorted by
+var sortColumns = map[string]bool{
+	"id":    true,
+	"name":  true,
+	"price": true,
+	"sku":   true,
+}
+
+// SortField is a column used to sort the products
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSort reads a comma separated list of columns, a column starting with - is sorted in descending order
+// (e.g. -price,name). Only the columns in the whitelist are accepted.
+func ParseSort(s string) ([]SortField, error) {
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, column := range strings.Split(s, ",") {
+		column = strings.TrimSpace(column)
+		f := SortField{Column: column}
+		if strings.HasPrefix(column, "-") {
+			f = SortField{Column: column[1:], Desc: true}
+		}
+		if !sortColumns[f.Column] {
+			return nil, fmt.Errorf("sort by '%s' is not allowed", column)
+		}
+		if seen[f.Column] {
+			return nil, fmt.Errorf("sort column '%s' is repeated", f.Column)
+		}
+		seen[f.Column] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// ProductQuery describes which products GetAll has to read. The filters are all optional and they are put in AND.
+// The page can be selected using Offset or, better for big collections, using the keyset cursor After (only the
+// products coming after the cursor in the sort order are read).
+type ProductQuery struct {
+	IncludeDeleted bool
+	PriceMin       *float32
+	PriceMax       *float32
+	// SKU is the exact sku to search
+	SKU string
+	// SKUPrefix searches the products having a sku starting with the given value
+	SKUPrefix string
+	// Name searches the products having a name that contains the given value (case insensitive)
+	Name string
+	// Search is a full text search over name and description
+	Search string
+	Sort   []SortField
+	Limit  int
+	Offset int
+	After  *Cursor
+}
+
+// limit returns the page size to use, DefaultLimit if not set and never more than MaxLimit
+func (q ProductQuery) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultLimit
+	case q.Limit > MaxLimit:
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+// sortFields returns the sort columns always ending with id, that is unique and makes the order stable
+func (q ProductQuery) sortFields() []SortField {
+	var fields []SortField
+	for _, f := range q.Sort {
+		fields = append(fields, f)
+		if f.Column == "id" {
+			return fields
+		}
+	}
+	return append(fields, SortField{Column: "id"})
+}
+
+// sortKey returns the sort as a string (e.g. -price,name,id)
+func (q ProductQuery) sortKey() string {
+	var columns []string
+	for _, f := range q.sortFields() {
+		if f.Desc {
+			columns = append(columns, "-"+f.Column)
+		} else {
+			columns = append(columns, f.Column)
+		}
+	}
+	return strings.Join(columns, ",")
+}
+
+// ProductPage is a page of products read by GetAll
+type ProductPage struct {
+	Items      ProductsT `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	TotalCount int       `json:"total_count"`
+}
+
+// ToJSON encode the ProductPage object into a json representation in []byte
+func (p *ProductPage) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// Cursor is the position of the last product read: the values of its sort columns and the sort they refer to. The
+// caller receives it as an opaque string.
+type Cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// EncodeCursor returns the opaque cursor pointing after the product for the sort of the query
+func EncodeCursor(q ProductQuery, prod *Product) string {
+	c := Cursor{Sort: q.sortKey()}
+	for _, f := range q.sortFields() {
+		c.Values = append(c.Values, prod.columnValue(f.Column))
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor returns the cursor stored into the opaque string. The cursor is valid only for the same sort of the
+// query that created it.
+func DecodeCursor(q ProductQuery, s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	fields := q.sortFields()
+	if c.Sort != q.sortKey() || len(c.Values) != len(fields) {
+		return nil, ErrInvalidCursor
+	}
+	// check the types to not send garbage to the database
+	for i, f := range fields {
+		switch f.Column {
+		case "id":
+			id, ok := c.Values[i].(float64)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			c.Values[i] = int(id)
+		case "price":
+			price, ok := c.Values[i].(float64)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			c.Values[i] = float32(price)
+		default:
+			if _, ok := c.Values[i].(string); !ok {
+				return nil, ErrInvalidCursor
+			}
+		}
+	}
+	return &c, nil
+}
+
+// columnValue returns the value of the product for the given column
+func (p *Product) columnValue(column string) interface{} {
+	switch column {
+	case "name":
+		return p.Name
+	case "description":
+		return p.Description
+	case "price":
+		return p.Price
+	case "sku":
+		return p.SKU
+	}
+	return p.ID
+}
+
+// newProductPage builds the page from the products read. The repositories read one product more than the limit,
+// in that case there is a next page and its cursor is set.
+func newProductPage(q ProductQuery, items ProductsT, total int) *ProductPage {
+	limit := q.limit()
+	page := &ProductPage{Items: items, TotalCount: total}
+	if page.Items == nil {
+		page.Items = ProductsT{}
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = EncodeCursor(q, page.Items[limit-1])
+	}
+	return page
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// custom errors
+var (
+	ErrTokenNotFound = fmt.Errorf("refresh token not found")
+	ErrTokenRevoked  = fmt.Errorf("refresh token has been revoked")
+	ErrTokenReused   = fmt.Errorf("refresh token has already been used")
+)
+
+// RefreshToken is an issued refresh token. Every refresh token can be used only once: the refresh returns a new
+// token of the same Family, so that when an already used token comes back the whole family can be revoked.
+type RefreshToken struct {
+	JTI       string
+	Family    string
+	Username  string
+	ExpiresOn time.Time
+	UsedOn    *time.Time
+	RevokedOn *time.Time
+}
+
+// TokenRepository is the storage of the issued refresh tokens and of the revoked access tokens. PgTokens is the
+// implementation backed by PostgreSQL, MemTokens keeps everything in memory.
+type TokenRepository interface {
+	// AddRefresh stores a new refresh token
+	AddRefresh(ctx context.Context, token *RefreshToken) error
+	// UseRefresh marks the refresh token as used and returns it. ErrTokenNotFound is returned if the token doesn't
+	// exist or it is expired, ErrTokenRevoked if it has been revoked and ErrTokenReused if it has already been used.
+	// In the ErrTokenReused case the token is returned as well.
+	UseRefresh(ctx context.Context, jti string) (*RefreshToken, error)
+	// RevokeFamily revokes all the refresh tokens of the family
+	RevokeFamily(ctx context.Context, family string) error
+	// Revoke stores the id of an access token that can't be used anymore, expiresOn is the expiration of the
+	// token: after that the id can be forgotten
+	Revoke(ctx context.Context, jti string, expiresOn time.Time) error
+	// IsRevoked returns true if the access token id has been revoked
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// DeleteExpired removes the expired refresh tokens and revoked ids
+	DeleteExpired(ctx context.Context) error
+}
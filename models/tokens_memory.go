@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemTokens is a TokenRepository that keeps the tokens in memory. It is safe for concurrent use and it is mainly
+// intended for the tests, where a running PostgreSQL is not available.
+type MemTokens struct {
+	mu      sync.Mutex
+	refresh map[string]*RefreshToken
+	revoked map[string]time.Time
+}
+
+// NewMemTokens returns an empty in memory TokenRepository
+func NewMemTokens() *MemTokens {
+	return &MemTokens{refresh: make(map[string]*RefreshToken), revoked: make(map[string]time.Time)}
+}
+
+// AddRefresh stores a new refresh token
+func (m *MemTokens) AddRefresh(ctx context.Context, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *token
+	m.refresh[token.JTI] = &c
+	return nil
+}
+
+// UseRefresh marks the refresh token as used
+func (m *MemTokens) UseRefresh(ctx context.Context, jti string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refresh[jti]
+	now := time.Now()
+	switch {
+	case !ok || token.ExpiresOn.Before(now):
+		return nil, ErrTokenNotFound
+	case token.RevokedOn != nil:
+		return nil, ErrTokenRevoked
+	case token.UsedOn != nil:
+		c := *token
+		return &c, ErrTokenReused
+	}
+	token.UsedOn = &now
+	c := *token
+	return &c, nil
+}
+
+// RevokeFamily revokes all the refresh tokens of the family
+func (m *MemTokens) RevokeFamily(ctx context.Context, family string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range m.refresh {
+		if token.Family == family && token.RevokedOn == nil {
+			token.RevokedOn = &now
+		}
+	}
+	return nil
+}
+
+// Revoke stores the id of the access token
+func (m *MemTokens) Revoke(ctx context.Context, jti string, expiresOn time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revoked[jti] = expiresOn
+	return nil
+}
+
+// IsRevoked returns true if the access token id has been revoked
+func (m *MemTokens) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+// DeleteExpired removes the expired refresh tokens and revoked ids
+func (m *MemTokens) DeleteExpired(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for jti, token := range m.refresh {
+		if token.ExpiresOn.Before(now) {
+			delete(m.refresh, jti)
+		}
+	}
+	for jti, expiresOn := range m.revoked {
+		if expiresOn.Before(now) {
+			delete(m.revoked, jti)
+		}
+	}
+	return nil
+}
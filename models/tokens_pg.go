@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// PgTokens is the TokenRepository that reads and writes the refresh_tokens and revoked_tokens tables on PostgreSQL
+type PgTokens struct {
+	db DB
+}
+
+// NewPgTokens returns a TokenRepository that uses the given database
+func NewPgTokens(db DB) *PgTokens {
+	return &PgTokens{db}
+}
+
+// AddRefresh stores a new refresh token
+func (p *PgTokens) AddRefresh(ctx context.Context, token *RefreshToken) error {
+	_, err := p.db.Exec(ctx, "INSERT INTO refresh_tokens(jti, family, username, expires_on) VALUES($1, $2, $3, $4)",
+		token.JTI, token.Family, token.Username, token.ExpiresOn)
+	return err
+}
+
+// UseRefresh marks the refresh token as used, the update is conditional so that two concurrent refreshes with the
+// same token can't both succeed
+func (p *PgTokens) UseRefresh(ctx context.Context, jti string) (*RefreshToken, error) {
+	token := &RefreshToken{}
+	err := p.db.QueryRow(ctx, `UPDATE refresh_tokens SET used_on = now()
+		WHERE jti = $1 AND used_on IS NULL AND revoked_on IS NULL AND expires_on > now()
+		RETURNING jti, family, username, expires_on, used_on, revoked_on`, jti).
+		Scan(&token.JTI, &token.Family, &token.Username, &token.ExpiresOn, &token.UsedOn, &token.RevokedOn)
+	if err != pgx.ErrNoRows {
+		return token, err
+	}
+
+	// not updated: find out why
+	err = p.db.QueryRow(ctx, `SELECT jti, family, username, expires_on, used_on, revoked_on FROM refresh_tokens
+		WHERE jti = $1 AND expires_on > now()`, jti).
+		Scan(&token.JTI, &token.Family, &token.Username, &token.ExpiresOn, &token.UsedOn, &token.RevokedOn)
+	switch {
+	case err == pgx.ErrNoRows:
+		return nil, ErrTokenNotFound
+	case err != nil:
+		return nil, err
+	case token.RevokedOn != nil:
+		return nil, ErrTokenRevoked
+	}
+	return token, ErrTokenReused
+}
+
+// RevokeFamily revokes all the refresh tokens of the family
+func (p *PgTokens) RevokeFamily(ctx context.Context, family string) error {
+	_, err := p.db.Exec(ctx, "UPDATE refresh_tokens SET revoked_on = now() WHERE family = $1 AND revoked_on IS NULL",
+		family)
+	return err
+}
+
+// Revoke stores the id of the access token
+func (p *PgTokens) Revoke(ctx context.Context, jti string, expiresOn time.Time) error {
+	_, err := p.db.Exec(ctx, "INSERT INTO revoked_tokens(jti, expires_on) VALUES($1, $2) ON CONFLICT (jti) DO NOTHING",
+		jti, expiresOn)
+	return err
+}
+
+// IsRevoked returns true if the access token id has been revoked
+func (p *PgTokens) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := p.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpired removes the expired refresh tokens and revoked ids
+func (p *PgTokens) DeleteExpired(ctx context.Context) error {
+	if _, err := p.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE expires_on < now()"); err != nil {
+		return err
+	}
+	_, err := p.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_on < now()")
+	return err
+}
@@ -1,31 +1,40 @@
-/*
-Package models the structure (modeling) of the models.
-To avoid reading from a database the models are also inserted here.
-*/
 package models
 
 import (
 	"context"
 	"encoding/json"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/validation"
 	"io"
 	"time"
 )
 
+// User defines the structure for an API user. The ApiKey (the PHC string of the user password hash, see
+// security.PasswordHasher) is never serialized.
+type User struct {
+	ID          int        `json:"user-id"`
+	Username    string     `json:"username" validate:"required,short_text"`
+	Description string     `json:"description" validate:"long_text"`
+	Email       string     `json:"email" validate:"omitempty,email,max=200"`
+	ApiKey      string     `json:"-"`
+	UserType    string     `json:"user-type" validate:"omitempty,oneof=admin editor reader"`
+	Created     time.Time  `json:"created"`
+	Updated     *time.Time `json:"updated,omitempty"`
+	Disabled    bool       `json:"disabled"`
+	// FailedLogins are the consecutive failed logins, the logins are refused until LockedUntil
+	FailedLogins int        `json:"failed-logins"`
+	LockedUntil  *time.Time `json:"locked-until,omitempty"`
+}
+
+// custom errors
 var (
-	Users UsersT = UsersT{}
+	ErrUserNotFound = fmt.Errorf("user not found")
+	ErrUserExists   = fmt.Errorf("username already exists")
 )
 
-// User defines the structure for an API user
-type User struct {
-	ID          int     `json:"user-id"`
-	Username    string  `json:"username"`
-	Description string  `json:"description"`
-	Email       string  `json:"email"`
-	ApiKey      string `json:"api-key"`
-	Created     time.Time  `json:"created"`
-	Updated     time.Time  `json:"updated"`
-	Disabled    bool    `json:"disabled"`
+// Validate the structure
+func (p *User) Validate() error {
+	return validation.Struct(p)
 }
 
 // FromJSON fills User decoding the JSON read from the reader.
@@ -40,95 +49,66 @@ func (p *User) ToJSON() ([]byte, error) {
 	return json.Marshal(p)
 }
 
-// UsersT type to return directly a slice of Product
-type UsersT []*User
-
-// ToJSON encode the Products object into a json representation in []byte
-func (p *UsersT) ToJSON() ([]byte, error) {
-	return json.Marshal(p)
+// UserInput is the body accepted to create or update a user: the writable fields of the user plus the password,
+// that is only read and never returned
+type UserInput struct {
+	Username    string `json:"username"`
+	Description string `json:"description"`
+	Email       string `json:"email"`
+	UserType    string `json:"user-type"`
+	Disabled    bool   `json:"disabled"`
+	Password    string `json:"password"`
 }
 
-// SearchByUserPwd return a User object if the username and password match with the specified argument, otherwise
-// it returns nil
-func (p *UsersT) SearchByUserPwd(pool *pgxpool.Pool, username, password string) (user *User, err error) {
-	row := pool.QueryRow(context.Background(), `
-	SELECT user_id, username, created FROM users WHERE username=$1 and api_key=$2`,
-		username, password)
-
-	user = new(User)
-	err = row.Scan(&user.ID, &user.Username, &user.Created)
-	if err != nil {
-		return nil, err
-	}
-
-	return user, err
+// FromJSON fills UserInput decoding the JSON read from the reader, unknown fields are an error
+func (p *UserInput) FromJSON(r io.Reader) error {
+	e := json.NewDecoder(r)
+	e.DisallowUnknownFields()
+	return e.Decode(p)
 }
 
-// GetAll returns a slice of *Product.
-func (p *UsersT) GetAll(pool *pgxpool.Pool) (ProductsT, error) {
-	var productList ProductsT
-	rows, err := pool.Query(context.Background(), "SELECT id, name, description, price, sku FROM products")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	/*
-	   id SERIAL,
-	   name TEXT NOT NULL,
-	   description TEXT NOT NULL,
-	   price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-	   sku varchar(100),
-	*/
-	// iterate through the result set
-	for rows.Next() {
-		p := Product{}
-		err = rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.SKU)
-		if err != nil {
-			return nil, err
-		}
-		productList = append(productList, &p)
+// User returns the user with the writable fields taken from the input
+func (p *UserInput) User() *User {
+	return &User{
+		Username:    p.Username,
+		Description: p.Description,
+		Email:       p.Email,
+		UserType:    p.UserType,
+		Disabled:    p.Disabled,
 	}
-
-	// Any errors encountered by rows.Next or rows.Scan will be returned here
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
-	// return the list of products
-	return productList, nil
-}
-
-// Get the product reading db. All the fields are stored in the *Product object returned by the method.
-func (p *UsersT) Get(pool *pgxpool.Pool, id int) (prod *Product, err error) {
-	row := pool.QueryRow(context.Background(), "SELECT id, name, description, price, sku FROM products WHERE id=$1",
-		id)
-	prod = new(Product)
-	err = row.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.SKU)
-	return prod, err
 }
 
-// Add a new product to the products table
-func (p *UsersT) Add(pool *pgxpool.Pool, new *Product) error {
-	err := pool.QueryRow(context.Background(),
-		"INSERT INTO products(name, price, description, sku) VALUES($1, $2, $3, $4) RETURNING id",
-		(*new).Name, (*new).Price, (*new).Description, (*new).SKU).Scan(&new.ID)
+// UsersT type to return directly a slice of User
+type UsersT []*User
 
-	if err != nil {
-		return err
-	}
-	return nil
+// ToJSON encode the Users object into a json representation in []byte
+func (p *UsersT) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
 }
 
-// Update the product in the collection
-func (p *UsersT) Update(pool *pgxpool.Pool, prod *Product) error {
-	tag, err := pool.Exec(context.Background(), "UPDATE products SET name = $1, price = $2, "+
-		"description = $3, sku = $4 WHERE id = $5",
-		prod.Name, prod.Price, prod.Description, prod.SKU, prod.ID)
-	if err != nil {
-		return err
-	}
-	if tag.RowsAffected() != 1 {
-		return RecordNotFound
-	}
-	return nil
+// UserRepository is the storage used to read and write the users. PgUsers is the implementation backed by
+// PostgreSQL, MemUsers keeps everything in memory.
+type UserRepository interface {
+	// GetAll returns all the users ordered by id
+	GetAll(ctx context.Context) (UsersT, error)
+	// Get returns the user with the given id or ErrUserNotFound
+	Get(ctx context.Context, id int) (*User, error)
+	// GetByUsername returns the user with the given username or ErrUserNotFound
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	// Add stores a new user setting its ID and Created, ErrUserExists is returned if the username is taken
+	Add(ctx context.Context, user *User) error
+	// Update replaces the user with the same ID or returns ErrUserNotFound. The api key is written only if it is
+	// set, ErrUserExists is returned if the new username is taken.
+	Update(ctx context.Context, user *User) error
+	// SetApiKey replaces the password hash of the user
+	SetApiKey(ctx context.Context, id int, apiKey string) error
+	// SetDisabled enables or disables the user, a disabled user can't login
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	// AddLoginFailure increments the failed logins of the user and returns them
+	AddLoginFailure(ctx context.Context, id int) (int, error)
+	// SetLockedUntil refuses the logins of the user until the given time (nil unlocks it), if reset is true the
+	// failed logins restart from zero
+	SetLockedUntil(ctx context.Context, id int, until *time.Time, reset bool) error
+	// Delete removes the user or returns ErrUserNotFound
+	Delete(ctx context.Context, id int) error
 }
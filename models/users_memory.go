@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemUsers is a UserRepository that keeps the users in memory. It is safe for concurrent use and it is mainly
+// intended for the tests, where a running PostgreSQL is not available.
+type MemUsers struct {
+	mu     sync.RWMutex
+	users  map[int]*User
+	lastID int
+}
+
+// NewMemUsers returns an empty in memory UserRepository
+func NewMemUsers() *MemUsers {
+	return &MemUsers{users: make(map[int]*User)}
+}
+
+// GetAll returns all the users ordered by id
+func (m *MemUsers) GetAll(ctx context.Context) (UsersT, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userList := UsersT{}
+	for _, user := range m.users {
+		userList = append(userList, copyUser(user))
+	}
+	sort.Slice(userList, func(i, j int) bool { return userList[i].ID < userList[j].ID })
+	return userList, nil
+}
+
+// Get returns the user with the given id
+func (m *MemUsers) Get(ctx context.Context, id int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+// GetByUsername returns the user with the given username
+func (m *MemUsers) GetByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return copyUser(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// Add a new user to the store assigning the next available id
+func (m *MemUsers) Add(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.taken(user) {
+		return ErrUserExists
+	}
+	m.lastID++
+	user.ID = m.lastID
+	user.Created = time.Now()
+	user.Updated = nil
+	m.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// Update the user in the store, the api key is changed only if it is set
+func (m *MemUsers) Update(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if m.taken(user) {
+		return ErrUserExists
+	}
+	now := time.Now()
+	user.Created, user.Updated = stored.Created, &now
+	if len(user.ApiKey) == 0 {
+		user.ApiKey = stored.ApiKey
+	}
+	user.FailedLogins, user.LockedUntil = stored.FailedLogins, stored.LockedUntil
+	m.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// SetApiKey replaces the password hash of the user
+func (m *MemUsers) SetApiKey(ctx context.Context, id int, apiKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.ApiKey = apiKey
+	return nil
+}
+
+// SetDisabled enables or disables the user
+func (m *MemUsers) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	now := time.Now()
+	user.Disabled, user.Updated = disabled, &now
+	return nil
+}
+
+// AddLoginFailure increments the failed logins of the user
+func (m *MemUsers) AddLoginFailure(ctx context.Context, id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	user.FailedLogins++
+	return user.FailedLogins, nil
+}
+
+// SetLockedUntil refuses the logins of the user until the given time
+func (m *MemUsers) SetLockedUntil(ctx context.Context, id int, until *time.Time, reset bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.LockedUntil = nil
+	if until != nil {
+		c := *until
+		user.LockedUntil = &c
+	}
+	if reset {
+		user.FailedLogins = 0
+	}
+	return nil
+}
+
+// Delete removes the user from the store
+func (m *MemUsers) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, id)
+	return nil
+}
+
+// Reset removes all the users and restarts the ids from 1
+func (m *MemUsers) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = make(map[int]*User)
+	m.lastID = 0
+}
+
+// taken returns true if another user has the same username, the caller must hold the lock
+func (m *MemUsers) taken(user *User) bool {
+	for _, u := range m.users {
+		if u.Username == user.Username && u.ID != user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// copyUser returns a copy of the user, so that the stored one can't be changed by the callers
+func copyUser(user *User) *User {
+	c := *user
+	if user.Updated != nil {
+		updated := *user.Updated
+		c.Updated = &updated
+	}
+	if user.LockedUntil != nil {
+		until := *user.LockedUntil
+		c.LockedUntil = &until
+	}
+	return &c
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+// userColumns are the columns read for a user, in the order used by scanUser
+const userColumns = "user_id, username, COALESCE(description, ''), COALESCE(email, ''), COALESCE(api_key, ''), " +
+	"COALESCE(user_type, ''), created, updated, disabled, failed_logins, locked_until"
+
+// PgUsers is the UserRepository that reads and writes the users table on PostgreSQL
+type PgUsers struct {
+	db DB
+}
+
+// NewPgUsers returns a UserRepository that uses the given database
+func NewPgUsers(db DB) *PgUsers {
+	return &PgUsers{db}
+}
+
+// GetAll returns all the users ordered by id
+func (p *PgUsers) GetAll(ctx context.Context) (UsersT, error) {
+	userList := UsersT{}
+	rows, err := p.db.Query(ctx, "SELECT "+userColumns+" FROM users ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		userList = append(userList, user)
+	}
+	return userList, rows.Err()
+}
+
+// Get the user reading db
+func (p *PgUsers) Get(ctx context.Context, id int) (*User, error) {
+	return scanUser(p.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE user_id = $1", id))
+}
+
+// GetByUsername returns the user with the given username
+func (p *PgUsers) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return scanUser(p.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+// Add a new user to the users table
+func (p *PgUsers) Add(ctx context.Context, user *User) error {
+	err := p.db.QueryRow(ctx, `INSERT INTO users(username, description, email, api_key, api_key_updated, user_type,
+		created, disabled) VALUES($1, $2, $3, $4, now(), $5, now(), $6)
+		ON CONFLICT (username) DO NOTHING RETURNING user_id, created`,
+		user.Username, user.Description, user.Email, user.ApiKey, user.UserType, user.Disabled).
+		Scan(&user.ID, &user.Created)
+	if err == pgx.ErrNoRows {
+		return ErrUserExists
+	}
+	return err
+}
+
+// Update the user, the api key is changed only if it is set
+func (p *PgUsers) Update(ctx context.Context, user *User) error {
+	err := p.db.QueryRow(ctx, `UPDATE users SET username = $1, description = $2, email = $3,
+		user_type = $4, disabled = $5, updated = now(),
+		api_key = CASE WHEN $6 = '' THEN api_key ELSE $6 END,
+		api_key_updated = CASE WHEN $6 = '' THEN api_key_updated ELSE now() END
+		WHERE user_id = $7 RETURNING created, updated, failed_logins, locked_until`,
+		user.Username, user.Description, user.Email, user.UserType, user.Disabled, user.ApiKey, user.ID).
+		Scan(&user.Created, &user.Updated, &user.FailedLogins, &user.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if uniqueViolation(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+// SetApiKey replaces the password hash of the user
+func (p *PgUsers) SetApiKey(ctx context.Context, id int, apiKey string) error {
+	tag, err := p.db.Exec(ctx, "UPDATE users SET api_key = $1, api_key_updated = now() WHERE user_id = $2",
+		apiKey, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetDisabled enables or disables the user
+func (p *PgUsers) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	tag, err := p.db.Exec(ctx, "UPDATE users SET disabled = $1, updated = now() WHERE user_id = $2", disabled, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AddLoginFailure increments the failed logins of the user, the increment is atomic so that the concurrent logins
+// are all counted
+func (p *PgUsers) AddLoginFailure(ctx context.Context, id int) (int, error) {
+	var failures int
+	err := p.db.QueryRow(ctx, `UPDATE users SET failed_logins = failed_logins + 1 WHERE user_id = $1
+		RETURNING failed_logins`, id).Scan(&failures)
+	if err == pgx.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return failures, err
+}
+
+// SetLockedUntil refuses the logins of the user until the given time
+func (p *PgUsers) SetLockedUntil(ctx context.Context, id int, until *time.Time, reset bool) error {
+	tag, err := p.db.Exec(ctx, `UPDATE users SET locked_until = $1,
+		failed_logins = CASE WHEN $2 THEN 0 ELSE failed_logins END WHERE user_id = $3`, until, reset, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Delete the user from the users table
+func (p *PgUsers) Delete(ctx context.Context, id int) error {
+	tag, err := p.db.Exec(ctx, "DELETE FROM users WHERE user_id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// scanUser reads a user selected with userColumns
+func scanUser(row pgx.Row) (*User, error) {
+	user := new(User)
+	err := row.Scan(&user.ID, &user.Username, &user.Description, &user.Email, &user.ApiKey, &user.UserType,
+		&user.Created, &user.Updated, &user.Disabled, &user.FailedLogins, &user.LockedUntil)
+	if err == pgx.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// uniqueViolation returns true if err is the PostgreSQL unique_violation error
+func uniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
@@ -0,0 +1,193 @@
+/*
+Package problem returns the errors of the API as RFC 7807 problem details (application/problem+json).
+
+The handlers create a typed error (NotFound, Validation, Conflict, ...) and write it with Write. Any other error is an
+internal error: it is logged with the logger of the request and the caller only gets a generic message, so that the
+details of the database or of the libraries never reach the clients.
+*/
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/utils"
+	"net/http"
+)
+
+// ContentType is the media type of the problem details
+const ContentType = "application/problem+json"
+
+// TypePrefix is the prefix of the type URI of every problem, it is followed by the kind of the problem
+const TypePrefix = "urn:rest-api:problem:"
+
+// kinds of problem, the last part of the type URI
+const (
+	KindBadRequest           = "bad-request"
+	KindValidation           = "validation"
+	KindUnauthorized         = "unauthorized"
+	KindForbidden            = "forbidden"
+	KindNotFound             = "not-found"
+	KindMethodNotAllowed     = "method-not-allowed"
+	KindConflict             = "conflict"
+	KindPreconditionFailed   = "precondition-failed"
+	KindUnsupportedMediaType = "unsupported-media-type"
+	KindTooManyRequests      = "too-many-requests"
+	KindInternal             = "internal"
+)
+
+// internalDetail is the detail of every internal error, the real error is only logged
+const internalDetail = "an unexpected error occurred, please report the request_id to the administrators"
+
+// Problem is the body of an error response
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is the error of a single field of the request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error with the status code and the details to return to the caller
+type Error struct {
+	Status int
+	Kind   string
+	Title  string
+	Detail string
+	Fields []FieldError
+	// Err is the cause of the error, it is logged for the internal errors and never returned to the caller
+	Err error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", e.Kind, e.Detail, e.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Problem returns the problem details of the error for the request
+func (e *Error) Problem(r *http.Request) Problem {
+	p := Problem{
+		Type:   TypePrefix + e.Kind,
+		Title:  e.Title,
+		Status: e.Status,
+		Detail: e.Detail,
+		Errors: e.Fields,
+	}
+	if r != nil {
+		p.Instance = r.URL.Path
+		p.RequestID = utils.RequestID(r.Context())
+	}
+	return p
+}
+
+// New returns an error of the given kind
+func New(status int, kind, detail string) *Error {
+	return &Error{Status: status, Kind: kind, Title: http.StatusText(status), Detail: detail}
+}
+
+// BadRequest is a request that can't be understood (e.g. a wrong query parameter)
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, KindBadRequest, detail)
+}
+
+// Unauthorized is a call without valid credentials
+func Unauthorized(detail string) *Error {
+	return New(http.StatusUnauthorized, KindUnauthorized, detail)
+}
+
+// Forbidden is a call of a caller without the required role or permission
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, KindForbidden, detail)
+}
+
+// NotFound is a resource that doesn't exist
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, KindNotFound, detail)
+}
+
+// MethodNotAllowed is a method not supported by the resource
+func MethodNotAllowed(detail string) *Error {
+	return New(http.StatusMethodNotAllowed, KindMethodNotAllowed, detail)
+}
+
+// Conflict is a request conflicting with the state of the resource (e.g. a duplicate)
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, KindConflict, detail)
+}
+
+// PreconditionFailed is a resource changed since the version the caller knows (If-Match)
+func PreconditionFailed(detail string) *Error {
+	return New(http.StatusPreconditionFailed, KindPreconditionFailed, detail)
+}
+
+// UnsupportedMediaType is a body in a format the resource doesn't accept
+func UnsupportedMediaType(detail string) *Error {
+	return New(http.StatusUnsupportedMediaType, KindUnsupportedMediaType, detail)
+}
+
+// TooManyRequests is a caller that has to wait before calling again, the Retry-After header is set by the handler
+func TooManyRequests(detail string) *Error {
+	return New(http.StatusTooManyRequests, KindTooManyRequests, detail)
+}
+
+// Internal wraps an unexpected error, only a generic detail is returned to the caller
+func Internal(err error) *Error {
+	e := New(http.StatusInternalServerError, KindInternal, internalDetail)
+	e.Err = err
+	return e
+}
+
+// Write writes the error as problem details. An error that is not an *Error is written as an internal error. The
+// internal errors are logged with the id of the request.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal(err)
+	}
+	p := e.Problem(r)
+	if e.Status >= http.StatusInternalServerError {
+		cause := "unknown error"
+		if e.Err != nil {
+			cause = e.Err.Error()
+		}
+		logger := logging.FromContext(context.Background())
+		if r != nil {
+			logger = logging.FromContext(r.Context())
+		}
+		logger.WithField("path", p.Instance).Errorf("request failed: %s", cause)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		body = []byte(fmt.Sprintf(`{"type":%q,"title":%q,"status":%d}`, p.Type, p.Title, p.Status))
+	}
+	// the headers must be set before WriteHeader
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
+
+// Handler returns a handler always writing the error, e.g. for the routes that don't exist
+func Handler(e *Error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, e)
+	})
+}
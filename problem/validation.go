@@ -0,0 +1,74 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/validation"
+	"gopkg.in/go-playground/validator.v9"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Validation returns the error for a body that is not valid. The errors of the validator become the field errors
+// of the problem, any other error is only used as the detail.
+func Validation(err error) *Error {
+	e := New(http.StatusBadRequest, KindValidation, "the request body is not valid")
+	e.Title = "Validation Failed"
+	e.Err = err
+	if fields := Fields(err); len(fields) > 0 {
+		e.Fields = fields
+	} else if err != nil {
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+// Fields returns the field errors of a validation error, nil if err doesn't come from the validator
+func Fields(err error) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{Field: fieldName(fe), Rule: fe.Tag(), Message: validation.Message(fe)})
+	}
+	return fields
+}
+
+// fieldName returns the path of the field without the name of the validated struct (e.g. Product.price is price)
+func fieldName(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+// InvalidJSON returns the error for a body that can't be decoded: the message of the decoder is translated so that
+// the Go types of the models are not shown to the caller
+func InvalidJSON(err error) *Error {
+	var (
+		syntax    *json.SyntaxError
+		fieldType *json.UnmarshalTypeError
+	)
+	e := BadRequest("the request body is not a valid JSON document")
+	e.Err = err
+	switch {
+	case errors.Is(err, io.EOF):
+		e.Detail = "the request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		e.Detail = "the request body is truncated"
+	case errors.As(err, &syntax):
+		e.Detail = fmt.Sprintf("the request body is not a valid JSON document (offset %d)", syntax.Offset)
+	case errors.As(err, &fieldType) && len(fieldType.Field) > 0:
+		e.Detail = "the request body has fields of the wrong type"
+		e.Fields = []FieldError{{Field: fieldType.Field, Rule: "type",
+			Message: fmt.Sprintf("%s is not a valid value", fieldType.Value)}}
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		e.Detail = "the request body has " + strings.TrimPrefix(err.Error(), "json: ")
+	}
+	return e
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// apiKeyTag starts every api key, so that a leaked key can be recognized (e.g. by the secret scanners)
+const apiKeyTag = "rak_"
+
+// NewApiKey returns a random api key, its public prefix and the hash to store. The key has the format
+// rak_<prefix>_<secret>: the prefix finds the key in the storage and the 256 bits secret authenticates it.
+func NewApiKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 6+32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = apiKeyTag + hex.EncodeToString(b[:6])
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(b[6:])
+	return key, prefix, HashApiKey(key), nil
+}
+
+// ApiKeyPrefix returns the prefix of the key, false if the key hasn't the right format
+func ApiKeyPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyTag) {
+		return "", false
+	}
+	i := strings.Index(key[len(apiKeyTag):], "_")
+	if i <= 0 || len(key) == len(apiKeyTag)+i+1 {
+		return "", false
+	}
+	return key[:len(apiKeyTag)+i], true
+}
+
+// HashApiKey returns the hash of the key to store. The key is random and long enough to not need a slow and salted
+// hash like the passwords.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyApiKey compares the key with the stored hash in constant time
+func VerifyApiKey(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashApiKey(key)), []byte(hash)) == 1
+}
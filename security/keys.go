@@ -0,0 +1,307 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"math/big"
+)
+
+// signing algorithms supported for the tokens
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// KeyConfig describes a signing key in the configuration. A key with only the public key can verify the tokens
+// but not sign them: it is a retired key kept until the tokens it signed are expired.
+type KeyConfig struct {
+	ID         string `yaml:"id"`
+	Algorithm  string `yaml:"algorithm"`
+	PrivateKey string `yaml:"private_key"`
+	PublicKey  string `yaml:"public_key"`
+}
+
+// SigningKey is a key used to sign and verify the tokens, Private is nil for the retired keys
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+}
+
+// KeySet holds the keys of the tokens: the active key signs the new tokens, every key verifies the tokens having
+// its id in the kid header
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+// LoadKeySet reads the keys from the PEM files, active is the id of the key used to sign. If it is empty the
+// first key having a private key is used.
+func LoadKeySet(configs []KeyConfig, active string) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*SigningKey{}}
+	for _, c := range configs {
+		key, err := loadKey(c)
+		if err != nil {
+			return nil, fmt.Errorf("signing key '%s': %s", c.ID, err.Error())
+		}
+		if err = set.add(key); err != nil {
+			return nil, err
+		}
+		if set.active == nil && key.Private != nil && (len(active) == 0 || active == key.ID) {
+			set.active = key
+		}
+	}
+	if set.active == nil {
+		return nil, fmt.Errorf("the active signing key '%s' doesn't exist or it has no private key", active)
+	}
+	return set, nil
+}
+
+// NewEphemeralKeySet returns a key set with a random Ed25519 key. The tokens it signs are not valid anymore after
+// a restart and they can't be verified by other replicas: use it only when no keys are configured.
+func NewEphemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &SigningKey{ID: "ephemeral-" + uuid.NewString()[:8], Algorithm: EdDSA, Private: private, Public: public}
+	set := &KeySet{keys: map[string]*SigningKey{}, active: key}
+	return set, set.add(key)
+}
+
+// add stores the key checking that its id is unique
+func (k *KeySet) add(key *SigningKey) error {
+	if _, ok := k.keys[key.ID]; ok {
+		return fmt.Errorf("signing key id '%s' is repeated", key.ID)
+	}
+	k.keys[key.ID] = key
+	k.order = append(k.order, key.ID)
+	return nil
+}
+
+// Active returns the key used to sign the new tokens
+func (k *KeySet) Active() *SigningKey {
+	return k.active
+}
+
+// Sign creates a token with the claims signed by the active key, the key id is set into the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.active.Algorithm), claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.Private)
+}
+
+// Parse verifies the token and fills the claims. The key is selected by the kid header and the algorithm of the
+// token must be the one of the key: a token can't choose how it is verified (e.g. alg none or HS256 signed with
+// a public key).
+func (k *KeySet) Parse(token string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key '%s'", kid)
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing algorithm %s", t.Method.Alg())
+		}
+		return key.Public, nil
+	})
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the set of public keys published to verify the tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, the retired keys included
+func (k *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range k.order {
+		key := k.keys[id]
+		jwk := JWK{Kid: key.ID, Alg: key.Algorithm, Use: "sig"}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty, jwk.N = "RSA", encodeBase64URL(pub.N.Bytes())
+			jwk.E = encodeBase64URL(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty, jwk.Crv = "EC", pub.Curve.Params().Name
+			jwk.X, jwk.Y = encodeBase64URL(padded(pub.X, size)), encodeBase64URL(padded(pub.Y, size))
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", encodeBase64URL(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// PublicKey returns the public key described by the JWK
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(j.N)
+		e, errE := base64.RawURLEncoding.DecodeString(j.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwk '%s': wrong RSA key", j.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("jwk '%s': curve '%s' not supported", j.Kid, j.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(j.X)
+		y, errY := base64.RawURLEncoding.DecodeString(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("jwk '%s': wrong EC key", j.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("jwk '%s': the point is not on the curve", j.Kid)
+		}
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk '%s': wrong OKP key", j.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwk '%s': key type '%s' not supported", j.Kid, j.Kty)
+}
+
+// loadKey reads the key from the PEM files checking that it can be used with the algorithm
+func loadKey(c KeyConfig) (*SigningKey, error) {
+	if len(c.ID) == 0 {
+		return nil, fmt.Errorf("id is mandatory")
+	}
+	key := &SigningKey{ID: c.ID, Algorithm: c.Algorithm}
+	switch {
+	case len(c.PrivateKey) > 0:
+		block, err := readPEM(c.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if key.Private, err = parsePrivateKey(block); err != nil {
+			return nil, err
+		}
+		key.Public = key.Private.Public()
+	case len(c.PublicKey) > 0:
+		block, err := readPEM(c.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if key.Public, err = parsePublicKey(block); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("private_key or public_key is mandatory")
+	}
+
+	ok := false
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		ok = c.Algorithm == RS256 && pub.N.BitLen() >= 2048
+	case *ecdsa.PublicKey:
+		ok = c.Algorithm == ES256 && pub.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		ok = c.Algorithm == EdDSA
+	}
+	if !ok {
+		return nil, fmt.Errorf("the key can't be used with the algorithm '%s' (supported: %s with RSA 2048+, "+
+			"%s with P-256, %s with Ed25519)", c.Algorithm, RS256, ES256, EdDSA)
+	}
+	return key, nil
+}
+
+// readPEM returns the first PEM block of the file
+func readPEM(path string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	return block, nil
+}
+
+// parsePrivateKey reads a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key type not supported")
+	}
+	return signer, nil
+}
+
+// parsePublicKey reads a PKIX or PKCS#1 (RSA) public key
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// padded returns the big endian bytes of n left padded with zeros to size
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
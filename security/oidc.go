@@ -0,0 +1,304 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default values of OIDCConfig
+const (
+	defaultOIDCLeeway     = 30 * time.Second
+	defaultOIDCJWKSTTL    = time.Hour
+	defaultUsernameClaim  = "preferred_username"
+	defaultJWKSMinRefresh = 30 * time.Second
+)
+
+// OIDCConfig configures the validation of the tokens issued by an OpenID Connect identity provider
+type OIDCConfig struct {
+	// Issuer is the iss of the tokens, the discovery document is read from <Issuer>/.well-known/openid-configuration.
+	// The OIDC mode is disabled if it is empty.
+	Issuer string `yaml:"issuer"`
+	// Audience must be one of the aud of the tokens
+	Audience string `yaml:"audience"`
+	// UsernameClaim is the claim with the username (default preferred_username, sub if it is missing)
+	UsernameClaim string `yaml:"username_claim"`
+	// RoleClaim is the claim with the roles or the groups of the user, a string or a list of strings. The nested
+	// claims are separated by dots (e.g. realm_access.roles).
+	RoleClaim string `yaml:"role_claim"`
+	// Roles maps the values of RoleClaim to the local roles, the most powerful local role found is used
+	Roles map[string]string `yaml:"roles"`
+	// DefaultRole is the role of the users without a mapped value, if empty those users are rejected
+	DefaultRole string `yaml:"default_role"`
+	// Leeway is the clock skew tolerated on exp and nbf (default 30s)
+	Leeway time.Duration `yaml:"leeway"`
+	// JWKSTTL is how long the keys are cached before fetching them again (default 1h)
+	JWKSTTL time.Duration `yaml:"jwks_ttl"`
+	// JWKSMinRefresh is the minimum time between two fetches of the keys caused by an unknown kid, so that the
+	// tokens with random kids can't flood the identity provider (default 30s)
+	JWKSMinRefresh time.Duration `yaml:"jwks_min_refresh"`
+}
+
+// oidcKey is a key of the identity provider with the only algorithm it can verify
+type oidcKey struct {
+	algorithm string
+	public    crypto.PublicKey
+}
+
+// OIDCVerifier validates the tokens of an identity provider. The discovery document and the keys are fetched at the
+// first use and cached: the keys are fetched again when they are older than JWKSTTL or when a token has an unknown
+// kid (the provider has rotated its keys).
+type OIDCVerifier struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu        sync.RWMutex
+	jwksURI   string
+	keys      map[string]oidcKey
+	fetchedOn time.Time
+	// triedOn is the time of the last fetch caused by an unknown kid, successful or not
+	triedOn time.Time
+}
+
+// NewOIDCVerifier returns the verifier for the identity provider, client is used for the discovery and the keys
+// (http.DefaultClient if nil)
+func NewOIDCVerifier(cfg OIDCConfig, client *http.Client) (*OIDCVerifier, error) {
+	if len(cfg.Issuer) == 0 || len(cfg.Audience) == 0 {
+		return nil, fmt.Errorf("oidc: issuer and audience are mandatory")
+	}
+	if len(cfg.DefaultRole) > 0 && len(rolePermissions[cfg.DefaultRole]) == 0 {
+		return nil, fmt.Errorf("oidc: unknown default_role '%s'", cfg.DefaultRole)
+	}
+	for external, role := range cfg.Roles {
+		if len(rolePermissions[role]) == 0 {
+			return nil, fmt.Errorf("oidc: '%s' is mapped to the unknown role '%s'", external, role)
+		}
+	}
+	if len(cfg.UsernameClaim) == 0 {
+		cfg.UsernameClaim = defaultUsernameClaim
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = defaultOIDCLeeway
+	}
+	if cfg.JWKSTTL <= 0 {
+		cfg.JWKSTTL = defaultOIDCJWKSTTL
+	}
+	if cfg.JWKSMinRefresh <= 0 {
+		cfg.JWKSMinRefresh = defaultJWKSMinRefresh
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OIDCVerifier{cfg: cfg, client: client}, nil
+}
+
+// Issuer returns the iss of the tokens validated by the verifier
+func (v *OIDCVerifier) Issuer() string {
+	return v.cfg.Issuer
+}
+
+// Refresh fetches the discovery document and the keys of the identity provider
+func (v *OIDCVerifier) Refresh(ctx context.Context) error {
+	v.mu.RLock()
+	jwksURI := v.jwksURI
+	v.mu.RUnlock()
+
+	if len(jwksURI) == 0 {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.get(ctx, url, &discovery); err != nil {
+			return err
+		}
+		if discovery.Issuer != v.cfg.Issuer || len(discovery.JWKSURI) == 0 {
+			return fmt.Errorf("oidc: the discovery document is for the issuer '%s' or it has no jwks_uri",
+				discovery.Issuer)
+		}
+		jwksURI = discovery.JWKSURI
+	}
+
+	var set JWKS
+	if err := v.get(ctx, jwksURI, &set); err != nil {
+		return err
+	}
+	keys := map[string]oidcKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			// a key of an unsupported type doesn't prevent to use the others
+			continue
+		}
+		keys[jwk.Kid] = oidcKey{algorithm: jwkAlgorithm(jwk), public: public}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.jwksURI, v.keys, v.fetchedOn = jwksURI, keys, time.Now()
+	return nil
+}
+
+// Verify validates signature, iss, aud, exp and nbf of the token and returns its claims with the local name and role
+// claims added
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	// the time claims are checked below with the leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.algorithm {
+			return nil, fmt.Errorf("unexpected signing algorithm %s", t.Method.Alg())
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, fmt.Errorf("token issued by '%s'", iss)
+	}
+	if !claims.VerifyAudience(v.cfg.Audience, true) && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return nil, fmt.Errorf("token not issued for the audience '%s'", v.cfg.Audience)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("incorrect exp payload property")
+	}
+	if now.Add(-v.cfg.Leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+
+	name, _ := claims[v.cfg.UsernameClaim].(string)
+	if len(name) == 0 {
+		name, _ = claims["sub"].(string)
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("the token has no %s and no sub", v.cfg.UsernameClaim)
+	}
+	role := v.role(claims)
+	if len(role) == 0 {
+		return nil, fmt.Errorf("no role is mapped for the user '%s'", name)
+	}
+	claims["name"], claims["role"] = name, role
+	return claims, nil
+}
+
+// key returns the key with the given kid, the keys are fetched again if they are too old or if the kid is unknown
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (oidcKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	refresh := time.Since(v.fetchedOn) >= v.cfg.JWKSTTL
+	if !ok && time.Since(v.triedOn) >= v.cfg.JWKSMinRefresh {
+		refresh, v.triedOn = true, time.Now()
+	}
+	v.mu.Unlock()
+
+	if refresh {
+		err := v.Refresh(ctx)
+		if err != nil && !ok {
+			return key, err
+		}
+		// if the provider is not reachable the cached key is still good, otherwise the fresh keys are used
+		if err == nil {
+			v.mu.RLock()
+			key, ok = v.keys[kid]
+			v.mu.RUnlock()
+		}
+	}
+	if !ok {
+		return key, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+	return key, nil
+}
+
+// role returns the most powerful local role mapped from the values of the role claim, DefaultRole if none is mapped
+func (v *OIDCVerifier) role(claims jwt.MapClaims) string {
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(v.cfg.RoleClaim, ".") {
+		m, _ := value.(map[string]interface{})
+		value = m[part]
+	}
+	var values []interface{}
+	switch t := value.(type) {
+	case string:
+		values = []interface{}{t}
+	case []interface{}:
+		values = t
+	}
+
+	role := ""
+	for _, val := range values {
+		s, _ := val.(string)
+		if mapped, ok := v.cfg.Roles[s]; ok && len(rolePermissions[mapped]) > len(rolePermissions[role]) {
+			role = mapped
+		}
+	}
+	if len(role) == 0 {
+		return v.cfg.DefaultRole
+	}
+	return role
+}
+
+// get reads the JSON document at url
+func (v *OIDCVerifier) get(ctx context.Context, url string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// jwkAlgorithm returns the algorithm of the key, the one of the key type if the JWK doesn't tell it
+func jwkAlgorithm(jwk JWK) string {
+	if len(jwk.Alg) > 0 {
+		return jwk.Alg
+	}
+	switch {
+	case jwk.Kty == "RSA":
+		return RS256
+	case jwk.Kty == "EC" && jwk.Crv == "P-384":
+		return "ES384"
+	case jwk.Kty == "EC":
+		return ES256
+	}
+	return EdDSA
+}
+
+// hasAudience returns true if aud, a string or a list of strings, contains audience. jwt-go v3 checks only the
+// string form.
+func hasAudience(aud interface{}, audience string) bool {
+	list, _ := aud.([]interface{})
+	for _, a := range list {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
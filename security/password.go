@@ -0,0 +1,195 @@
+/*
+Package security contains the primitives used to authenticate the callers.
+*/
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// password hashing algorithms
+const (
+	BcryptAlgorithm   = "bcrypt"
+	Argon2idAlgorithm = "argon2id"
+)
+
+// ErrUnknownHash is returned when a stored hash doesn't belong to any supported algorithm
+var ErrUnknownHash = fmt.Errorf("unknown password hash format")
+
+// PasswordHasher hashes the passwords as PHC strings (e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>) and
+// verifies them in constant time
+type PasswordHasher interface {
+	// Hash returns the PHC string of the password, created with a random salt
+	Hash(password string) (string, error)
+	// Owns returns true if the encoded hash has been created by this algorithm, whatever its parameters are
+	Owns(encoded string) bool
+	// Verify returns true if the password matches the encoded hash
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash returns true if the encoded hash has been created with parameters different from the current ones
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswordHasher returns the hasher for the algorithm using the default parameters, bcryptCost is used only by
+// bcrypt and if it is 0 the default cost is used
+func NewPasswordHasher(algorithm string, bcryptCost int) (PasswordHasher, error) {
+	switch algorithm {
+	case BcryptAlgorithm:
+		if bcryptCost == 0 {
+			bcryptCost = bcrypt.DefaultCost
+		}
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return &Bcrypt{Cost: bcryptCost}, nil
+	case Argon2idAlgorithm, "":
+		return NewArgon2id(), nil
+	}
+	return nil, fmt.Errorf("password algorithm '%s' is not supported, use %s or %s", algorithm,
+		BcryptAlgorithm, Argon2idAlgorithm)
+}
+
+// VerifyPassword checks the password against a stored hash created by any of the supported algorithms, legacy
+// unsalted SHA-256 hashes included. rehash is true when the password is correct but the hash should be replaced
+// with a new one created by preferred.
+func VerifyPassword(preferred PasswordHasher, password, encoded string) (ok, rehash bool, err error) {
+	for _, h := range []PasswordHasher{preferred, &Bcrypt{Cost: bcrypt.DefaultCost}, NewArgon2id(), legacySHA256{}} {
+		if !h.Owns(encoded) {
+			continue
+		}
+		ok, err = h.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, h != preferred || preferred.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Bcrypt is the PasswordHasher using bcrypt, its modular crypt format ($2a$<cost>$...) is used as PHC string
+type Bcrypt struct {
+	Cost int
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(h), err
+}
+
+func (b *Bcrypt) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id is the PasswordHasher using argon2id
+type Argon2id struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// NewArgon2id returns the argon2id hasher with the parameters suggested by RFC 9106 for memory constrained
+// environments
+func NewArgon2id() *Argon2id {
+	return &Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations,
+		a.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism,
+		uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	return err != nil || params.Memory != a.Memory || params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism || len(salt) != a.SaltLength || uint32(len(key)) != a.KeyLength
+}
+
+// decodeArgon2id reads the parameters, the salt and the key from the PHC string
+func decodeArgon2id(encoded string) (params *Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("argon2id version not supported")
+	}
+	params = &Argon2id{}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations,
+		&params.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("wrong argon2id parameters: %s", err.Error())
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, fmt.Errorf("wrong argon2id salt: %s", err.Error())
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, nil, nil, fmt.Errorf("wrong argon2id hash: %s", err.Error())
+	}
+	return params, salt, key, nil
+}
+
+// legacySHA256 verifies the unsalted hex SHA-256 hashes stored before the PasswordHasher was introduced. It can't
+// create new hashes and its hashes always need to be replaced.
+type legacySHA256 struct{}
+
+func (legacySHA256) Hash(password string) (string, error) {
+	return "", fmt.Errorf("SHA-256 hashes can't be created anymore")
+}
+
+func (legacySHA256) Owns(encoded string) bool {
+	_, err := hex.DecodeString(encoded)
+	return len(encoded) == 2*sha256.Size && err == nil
+}
+
+func (legacySHA256) Verify(password, encoded string) (bool, error) {
+	expected, _ := hex.DecodeString(encoded)
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(expected, sum[:]) == 1, nil
+}
+
+func (legacySHA256) NeedsRehash(encoded string) bool {
+	return true
+}
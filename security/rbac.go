@@ -0,0 +1,49 @@
+package security
+
+// roles, stored into users.user_type and written into the role claim of the tokens
+const (
+	// RoleAdmin can do everything
+	RoleAdmin = "admin"
+	// RoleEditor can read and change the products, not delete them
+	RoleEditor = "editor"
+	// RoleReader can only read the products, it is the role of the users without a user_type
+	RoleReader = "reader"
+)
+
+// permissions checked by RequirePermission
+const (
+	PermProductsRead = "products:read"
+	// PermProductsWrite allows to create, change and import the products
+	PermProductsWrite = "products:write"
+	// PermProductsDelete allows to soft delete and restore the products
+	PermProductsDelete = "products:delete"
+	// PermProductsReadDeleted allows to read the soft deleted products (include_deleted)
+	PermProductsReadDeleted = "products:read-deleted"
+	PermUsersManage         = "users:manage"
+)
+
+// rolePermissions are the permissions granted to every role
+var rolePermissions = map[string][]string{
+	RoleAdmin: {PermProductsRead, PermProductsWrite, PermProductsDelete, PermProductsReadDeleted,
+		PermUsersManage},
+	RoleEditor: {PermProductsRead, PermProductsWrite},
+	RoleReader: {PermProductsRead},
+}
+
+// RoleOf returns the role for a user_type, RoleReader if it is not set
+func RoleOf(userType string) string {
+	if len(userType) == 0 {
+		return RoleReader
+	}
+	return userType
+}
+
+// HasPermission returns true if the role has been granted the permission, an unknown role has no permissions
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
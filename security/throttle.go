@@ -0,0 +1,128 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginPolicy tells how long the logins are refused after the failures: every failure doubles the wait starting
+// from Backoff and after MaxFailures consecutive failures the logins are refused for Lockout
+type LoginPolicy struct {
+	MaxFailures int           `yaml:"max_failures"`
+	Backoff     time.Duration `yaml:"backoff"`
+	Lockout     time.Duration `yaml:"lockout"`
+}
+
+// default policies, used for the values not configured
+var (
+	DefaultUserLoginPolicy = LoginPolicy{MaxFailures: 5, Backoff: time.Second, Lockout: 15 * time.Minute}
+	DefaultIPLoginPolicy   = LoginPolicy{MaxFailures: 20, Backoff: 0, Lockout: 15 * time.Minute}
+)
+
+// WithDefaults returns the policy with the zero values taken from def, Backoff is kept if MaxFailures is set
+func (p LoginPolicy) WithDefaults(def LoginPolicy) LoginPolicy {
+	if p.MaxFailures <= 0 {
+		p.MaxFailures, p.Backoff = def.MaxFailures, def.Backoff
+	}
+	if p.Lockout <= 0 {
+		p.Lockout = def.Lockout
+	}
+	return p
+}
+
+// Delay returns how long the logins are refused after the given consecutive failures and if it is the lockout
+func (p LoginPolicy) Delay(failures int) (delay time.Duration, lockout bool) {
+	switch {
+	case failures <= 0:
+		return 0, false
+	case failures >= p.MaxFailures:
+		return p.Lockout, true
+	case p.Backoff <= 0:
+		return 0, false
+	}
+	delay = p.Backoff
+	for i := 1; i < failures && delay < p.Lockout; i++ {
+		delay *= 2
+	}
+	if delay > p.Lockout {
+		delay = p.Lockout
+	}
+	return delay, false
+}
+
+// throttleEntry are the failures of a key
+type throttleEntry struct {
+	failures     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+// Throttle counts the failed logins per key (e.g. the client IP) in memory applying the policy. A key is forgotten
+// when it had no failures for the lockout time. It is safe for concurrent use.
+type Throttle struct {
+	policy    LoginPolicy
+	mu        sync.Mutex
+	entries   map[string]*throttleEntry
+	lastPrune time.Time
+}
+
+// NewThrottle returns an empty throttle applying the policy
+func NewThrottle(policy LoginPolicy) *Throttle {
+	return &Throttle{policy: policy, entries: map[string]*throttleEntry{}, lastPrune: time.Now()}
+}
+
+// RetryAfter returns how long the key has to wait before the next login, zero if it can login now
+func (t *Throttle) RetryAfter(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		if wait := time.Until(e.blockedUntil); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Fail counts a failed login of the key and returns how long it has to wait before the next login
+func (t *Throttle) Fail(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.lastFailure) > t.policy.Lockout {
+		e = &throttleEntry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+	delay, lockout := t.policy.Delay(e.failures)
+	if lockout {
+		// the failures restart from zero after the lockout
+		e.failures = 0
+	}
+	e.blockedUntil = now.Add(delay)
+	return delay
+}
+
+// Reset forgets the failures of the key
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// prune removes the expired entries, at most once a minute
+func (t *Throttle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > t.policy.Lockout && now.After(e.blockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -6,14 +6,24 @@ import (
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/mas2020-golang/goutils/fs"
 	"github.com/mas2020-golang/goutils/output"
 	"github.com/mas2020-golang/rest-api/handlers"
+	"github.com/mas2020-golang/rest-api/health"
+	"github.com/mas2020-golang/rest-api/lifecycle"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/metrics"
+	"github.com/mas2020-golang/rest-api/migrations"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/tracing"
 	"github.com/mas2020-golang/rest-api/utils"
-	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"io"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"sync"
 	"time"
 )
 
@@ -24,119 +34,410 @@ var (
 )
 
 type App struct {
-	Router *mux.Router
-	DBPool *pgxpool.Pool
+	Router   *mux.Router
+	DBPool   *pgxpool.Pool
+	Products models.ProductRepository
+	Users    models.UserRepository
+	Tokens   models.TokenRepository
+	ApiKeys  models.ApiKeyRepository
+	Keys     *security.KeySet
+	// Admin is the router of the admin listener, nil if the metrics are served by Router
+	Admin *mux.Router
+	// Health runs the checks of the readiness probe, the dependencies register their checks here
+	Health *health.Checker
+	// Lifecycle closes the resources of the application when it stops
+	Lifecycle *lifecycle.Manager
+	// Loader reads the configuration, if nil the file and the environment are read without the command line flags
+	Loader *utils.Loader
+	// LogOutput receives the logs, os.Stdout if nil
+	LogOutput io.Writer
+	// TracerProvider creates the spans of the application, it is the global OpenTelemetry provider
+	TracerProvider *sdktrace.TracerProvider
 }
 
-func (a *App) Initialize(user, password, host, dbname string) {
-	var err error
-	// load config file
-	loadConfig()
+// Initialize prepares the application to serve the data of the database configured in the database section
+func (a *App) Initialize() {
+	a.Setup()
+	a.Connect()
 
-	// log settings
-	logrus.SetLevel(logrus.Level(utils.Server.Logging.Level))
-	logrus.SetFormatter(&output.TextFormatter{})
-	logrus.SetOutput(os.Stdout)
+	// apply the pending migrations
+	if utils.Server.Database.Migrate {
+		m, err := migrations.New(a.DBPool)
+		output.CheckErrorAndExitLog("", "unable to load the migrations: ", err)
+		applied, err := m.Up(context.Background())
+		output.CheckErrorAndExitLog("", "unable to migrate the database: ", err)
+		output.InfoLog("", fmt.Sprintf("database migrated, %d migrations applied", applied))
+	}
+	a.UseDatabase()
+	if err := a.Tokens.DeleteExpired(context.Background()); err != nil {
+		output.ErrorLog("", "unable to delete the expired tokens: "+err.Error())
+	}
 
-	// connection to the database
-	connectionString := fmt.Sprintf("postgres://%s:%s@%s:5432/%s", user, password, host, dbname)
-	config, err = pgxpool.ParseConfig(connectionString)
-	output.CheckErrorAndExitLog("","check the connection string: ", err)
+	a.initRouter()
+}
 
+// Connect opens the pool of connections to the database
+func (a *App) Connect() {
+	var err error
+	db := utils.Server.Database
+	config, err = pgxpool.ParseConfig(db.ConnString())
+	output.CheckErrorAndExitLog("", "check the connection string: ", err)
+	config.ConnConfig.ConnectTimeout = db.ConnectTimeout
+	config.MaxConns, config.MinConns = db.MaxConns, db.MinConns
+	config.MaxConnLifetime, config.MaxConnIdleTime = db.MaxConnLifetime, db.MaxConnIdleTime
 	a.DBPool, err = pgxpool.ConnectConfig(context.Background(), config)
-	output.CheckErrorAndExitLog("","unable to connect to database: ", err)
+	output.CheckErrorAndExitLog("", "unable to connect to database: ", err)
 	output.InfoLog("", "connection to the database OK!")
+	pool := a.DBPool
+	a.Lifecycle.Register("database", func(context.Context) error {
+		pool.Close()
+		return nil
+	})
+	metrics.RegisterPool(a.DBPool)
+}
 
+// UseDatabase sets the repositories backed by the database of DBPool
+func (a *App) UseDatabase() {
+	// a span for every statement, child of the span of the request
+	db := tracing.NewDB(a.DBPool)
+	a.Products = models.NewPgProducts(db)
+	a.Users = models.NewPgUsers(db)
+	a.Tokens = models.NewPgTokens(db)
+	a.ApiKeys = models.NewPgApiKeys(db)
+}
+
+// InitializeWithRepository prepares the application to serve the products, the users, the tokens and the api keys
+// from the given repositories without connecting to the database (e.g. using models.NewMemProducts,
+// models.NewMemUsers, models.NewMemTokens and models.NewMemApiKeys for the tests).
+func (a *App) InitializeWithRepository(products models.ProductRepository, users models.UserRepository,
+	tokens models.TokenRepository, apiKeys models.ApiKeyRepository) {
+	a.Setup()
+	a.Products = products
+	a.Users = users
+	a.Tokens = tokens
+	a.ApiKeys = apiKeys
+	a.initRouter()
+}
+
+// Setup loads the configuration (defaults, file, APP_* environment variables and flags of Loader) and applies the
+// log settings
+func (a *App) Setup() {
+	// load config file
+	if a.Loader == nil {
+		a.Loader = utils.NewLoader()
+	}
+	cfg, err := a.Loader.Load()
+	output.CheckErrorAndExitLog("", "", err)
+	utils.Server = cfg
+	a.Lifecycle = lifecycle.New()
+
+	// log settings
+	if a.LogOutput == nil {
+		a.LogOutput = os.Stdout
+	}
+	err = logging.Setup(utils.Server.Logging.Level, utils.Server.Logging.Format, a.LogOutput)
+	output.CheckErrorAndExitLog("", "wrong logging configuration: ", err)
+	// tracing settings
+	a.TracerProvider, err = tracing.Setup(utils.Server.Tracing, os.Stdout)
+	output.CheckErrorAndExitLog("", "wrong tracing configuration: ", err)
+	// the pending spans are sent when the application stops
+	a.Lifecycle.Register("tracing", a.TracerProvider.Shutdown)
+}
+
+// initRouter creates the router and loads the keys used for signing the tokens
+func (a *App) initRouter() {
 	a.Router = mux.NewRouter()
-	// create a pwd for the JWT signing algorithm
-	utils.Server.GeneratePwd()
+	a.initHealth()
+	a.loadKeys()
 	// init the routes
 	a.initRoutes()
+	a.initMetrics()
 }
 
-func (a *App) Run(addr string) {
-	//var err error
-	// http server parameters
-	s := &http.Server{
-		Addr:         addr,              // configure the bind address
-		Handler:      a.Router,          // set the default handler
-		IdleTimeout:  120 * time.Second, // max time for connections using the TCP Keep Alive
-		ReadTimeout:  1 * time.Second,   // max time to read request from the client
-		WriteTimeout: 1 * time.Second,   // max time to write response to the client
+// initMetrics serves the metrics on the router of the admin listener or, if no address is configured, with the API
+// (the configuration requires a token then)
+func (a *App) initMetrics() {
+	cfg := utils.Server.Metrics
+	if !cfg.Enabled {
+		return
+	}
+	path := cfg.Path
+	if len(path) == 0 {
+		path = "/metrics"
 	}
+	router := a.Router
+	if len(cfg.Listen) > 0 {
+		a.Admin = mux.NewRouter()
+		a.Admin.Use(utils.RequestIDMiddleware)
+		router = a.Admin
+	}
+	router.Handle(path, metrics.Handler(metrics.Default, cfg.Token)).Methods(http.MethodGet)
+}
 
-	// start the server in a separate go routine
-	go func() {
-		output.InfoLog("", "starting http server...")
-		s.ListenAndServe()
-		output.InfoLog("", "closing http server...")
-	}()
+// initHealth creates the checker of the readiness probe with the checks of the database, if the application uses it
+func (a *App) initHealth() {
+	a.Health = health.New(health.DefaultTimeout)
+	if a.DBPool == nil {
+		return
+	}
+	a.Health.Register("database", func(ctx context.Context) (string, error) {
+		return "", a.DBPool.Ping(ctx)
+	})
+	// the status only reads schema_migrations: the probe doesn't wait for the lock of a replica migrating
+	m, err := migrations.New(a.DBPool)
+	a.Health.Register("migrations", func(ctx context.Context) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		status, err := m.Status(ctx)
+		if err != nil {
+			return "", err
+		}
+		applied := 0
+		for _, s := range status {
+			if s.Applied {
+				applied++
+			}
+		}
+		message := fmt.Sprintf("%d of %d migrations applied", applied, len(status))
+		if applied < len(status) {
+			return message, fmt.Errorf("%d migrations pending", len(status)-applied)
+		}
+		return message, nil
+	})
+}
 
-	time.Sleep(time.Millisecond * 100)
-	output.InfoLog("", "http server is ready to accept connections")
-	// wait for a signal to shutdown the server
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, os.Interrupt)
+// loadKeys reads the signing keys from the configuration, without keys a random one is generated
+func (a *App) loadKeys() {
+	var err error
+	if len(utils.Server.Token.Keys) == 0 {
+		output.WarningLog("", "no signing keys configured, a random key is used: the tokens are not valid after "+
+			"a restart and on the other replicas")
+		a.Keys, err = security.NewEphemeralKeySet()
+	} else {
+		a.Keys, err = security.LoadKeySet(utils.Server.Token.Keys, utils.Server.Token.ActiveKey)
+	}
+	output.CheckErrorAndExitLog("", "unable to load the signing keys: ", err)
+	active := a.Keys.Active()
+	output.InfoLog("", fmt.Sprintf("tokens signed with the key '%s' (%s)", active.ID, active.Algorithm))
+}
 
-	// read from the channel
-	sig := <-sigChan
-	output.InfoLog("", fmt.Sprintf("received the %v signal", sig))
+// newOIDCVerifier returns the verifier of the tokens of the identity provider. The keys are fetched here only to find
+// out early a wrong configuration: if the provider is not reachable they are fetched again at the first token.
+func newOIDCVerifier() *security.OIDCVerifier {
+	// the requests to the identity provider are traced as children of the request needing the keys
+	client := &http.Client{Timeout: 10 * time.Second, Transport: tracing.Transport(nil)}
+	verifier, err := security.NewOIDCVerifier(utils.Server.OIDC, client)
+	output.CheckErrorAndExitLog("", "wrong oidc configuration: ", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = verifier.Refresh(ctx); err != nil {
+		output.WarningLog("", "unable to fetch the keys of the identity provider: "+err.Error())
+	} else {
+		output.InfoLog("", fmt.Sprintf("tokens issued by %s accepted", verifier.Issuer()))
+	}
+	return verifier
+}
 
-	// gracefully shutdown the server (after 10 seconds server is shutdown)
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	a.shutdown(ctx, s)
-	output.InfoLog("", "shutting down bye!")
-	os.Exit(0)
+// Run serves the API on addr (http.address if empty) until the process receives SIGINT or SIGTERM, then it stops the application (see
+// Serve). The error is the one that stopped the servers or of the shutdown, nil for a clean stop.
+func (a *App) Run(addr string) error {
+	if len(addr) == 0 {
+		addr = utils.Server.HTTP.Address
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen: %w", err)
+	}
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
+	return a.Serve(ctx, ln)
 }
 
-// Gracefully shutdown function
-func (a *App) shutdown(ctx context.Context, s *http.Server) {
-	c := make(chan string)
+// Serve serves the API on ln, and the admin router on its own listener, until ctx is done or a server fails. Then
+// it stops the application in order: the readiness probe fails, the servers stop accepting connections and drain
+// the requests in flight within shutdown.timeout, finally the closers of Lifecycle (database pool, spans exporter...)
+// are called in reverse order.
+func (a *App) Serve(ctx context.Context, ln net.Listener) error {
+	// http server parameters
+	s := &http.Server{
+		Handler:      a.Router,                       // set the default handler
+		IdleTimeout:  utils.Server.HTTP.IdleTimeout,  // max time for connections using the TCP Keep Alive
+		ReadTimeout:  utils.Server.HTTP.ReadTimeout,  // max time to read request from the client
+		WriteTimeout: utils.Server.HTTP.WriteTimeout, // max time to write response to the client
+	}
+	servers := []*http.Server{s}
+	failed := make(chan error, 2)
 	go func() {
-		output.TraceLog("", "some long running stuff...")
-		// db connection: close the pool
-		output.DebugLog("", "closing db connections...")
-		a.DBPool.Close()
-		output.DebugLog("", "closing db connections done!")
-		time.Sleep(time.Millisecond * 200)
-		c <- "cleanup operations done!"
+		if err := s.Serve(ln); err != http.ErrServerClosed {
+			failed <- fmt.Errorf("http server: %w", err)
+		}
 	}()
+	// the admin listener serves only the metrics
+	if a.Admin != nil {
+		cfg := utils.Server.Metrics
+		admin := &http.Server{Addr: cfg.Listen, Handler: a.Admin, ReadTimeout: cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout, IdleTimeout: cfg.IdleTimeout}
+		servers = append(servers, admin)
+		go func() {
+			output.InfoLog("", fmt.Sprintf("starting admin http server on %s...", admin.Addr))
+			if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+				failed <- fmt.Errorf("admin http server: %w", err)
+			}
+		}()
+	}
+	output.InfoLog("", fmt.Sprintf("http server is ready to accept connections on %s", ln.Addr()))
 
+	// wait for a signal or for the failure of a server
+	var errs lifecycle.Errors
 	select {
-	case done := <-c:
-		output.InfoLog("", fmt.Sprintf("normal shutdown: %s", done))
-		s.Shutdown(ctx)
 	case <-ctx.Done():
-		output.InfoLog("", "elapsed timeout")
-		s.Shutdown(ctx)
+		output.InfoLog("", "shutdown requested")
+	case err := <-failed:
+		output.ErrorLog("", err.Error())
+		errs = append(errs, err)
+	}
+	return append(errs, a.stop(servers)...).Err()
+}
+
+// stop fails the readiness probe, drains the servers and calls the closers
+func (a *App) stop(servers []*http.Server) lifecycle.Errors {
+	var errs lifecycle.Errors
+	a.Health.Shutdown()
+	if delay := utils.Server.Shutdown.Delay; delay > 0 {
+		// the load balancers see the failed probe before the listeners are closed
+		output.InfoLog("", fmt.Sprintf("waiting %s before closing the listeners...", delay))
+		time.Sleep(delay)
+	}
+
+	timeout := utils.Server.Shutdown.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output.InfoLog("", fmt.Sprintf("draining the requests in flight (at most %s)...", timeout))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				// the requests still running are interrupted
+				srv.Close()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("drain: %w", err))
+				mu.Unlock()
+			}
+		}(srv)
 	}
+	wg.Wait()
+
+	// the closers have their own deadline, also when the drain has used all the time
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), timeout)
+	defer closeCancel()
+	if err := a.Close(closeCtx); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		output.InfoLog("", "shutting down bye!")
+	}
+	return errs
+}
+
+// Close calls the closers of Lifecycle in reverse order, e.g. it closes the database pool
+func (a *App) Close(ctx context.Context) error {
+	return a.Lifecycle.Close(ctx)
 }
 
 // initRoutes inits the routes for the application
 func (a *App) initRoutes() {
+	// hasher for the new passwords
+	hasher, err := security.NewPasswordHasher(utils.Server.Password.Algorithm, utils.Server.Password.BcryptCost)
+	output.CheckErrorAndExitLog("", "wrong password configuration: ", err)
+	// login handler, it also authenticates the other calls
+	login := handlers.NewLogin(a.Users, hasher, a.Tokens, a.Keys, a.ApiKeys)
+	login.SetLoginPolicies(utils.Server.Login.User, utils.Server.Login.IP, utils.Server.Login.TrustForwardedFor)
+	if len(utils.Server.OIDC.Issuer) > 0 {
+		login.SetOIDC(newOIDCVerifier())
+	}
+
 	// new handler object
-	ph := handlers.NewProducts(a.DBPool)
-	// common middleware valid for all the calls
-	a.Router.Use(commonMiddleware)
+	ph := handlers.NewProducts(a.Products)
+	ph.SetWriteTimeout(utils.Server.HTTP.WriteTimeout)
+	// common middleware valid for all the calls, the routes not found are answered with problem details too
+	common := []mux.MiddlewareFunc{utils.RequestIDMiddleware, tracing.Middleware(utils.Server.Tracing.Service()),
+		logging.AccessLog, metrics.Middleware}
+	// the preflight requests match no route, they are answered by the middleware of the handlers below
+	if cors := utils.Server.HTTP.CORS; cors.Enabled() {
+		common = append(common, utils.CORSMiddleware(cors))
+	}
+	a.Router.Use(append(common, commonMiddleware)...)
+	a.Router.NotFoundHandler = chain(problem.Handler(problem.NotFound("resource not found")), common...)
+	a.Router.MethodNotAllowedHandler = chain(problem.Handler(problem.MethodNotAllowed(
+		"method not allowed for the resource")), common...)
+
+	// permissions required by the routes
+	read := handlers.RequirePermission(security.PermProductsRead)
+	write := handlers.RequirePermission(security.PermProductsWrite)
+	remove := handlers.RequirePermission(security.PermProductsDelete)
+
+	// bulk import (mux can't add ":bulk" to the /products sub router, paths there must start with a slash)
+	a.Router.Handle("/products:bulk", login.AuthMiddleware(write(http.HandlerFunc(ph.ImportProducts)))).
+		Methods(http.MethodPost)
 
 	// products sub router (for every call is checked the Token, for POST and PUT is also used the validation middleware
 	prodRouter := a.Router.PathPrefix("/products").Subrouter()
-	prodRouter.HandleFunc("", ph.GetProducts).Methods(http.MethodGet)
-	prodRouter.HandleFunc("/{id:[0-9]+}", ph.GetProduct).Methods(http.MethodGet)
-	prodRouter.Use(handlers.AuthMiddleware)
+	prodRouter.Handle("", read(http.HandlerFunc(ph.GetProducts))).Methods(http.MethodGet)
+	prodRouter.Handle("/{id:[0-9]+}", read(http.HandlerFunc(ph.GetProduct))).Methods(http.MethodGet)
+	prodRouter.Handle("/export", read(http.HandlerFunc(ph.ExportProducts))).Methods(http.MethodGet)
+	prodRouter.Handle("/{id:[0-9]+}", write(http.HandlerFunc(ph.PatchProduct))).Methods(http.MethodPatch)
+	prodRouter.Handle("/{id:[0-9]+}", remove(http.HandlerFunc(ph.DeleteProduct))).Methods(http.MethodDelete)
+	prodRouter.Handle("/{id:[0-9]+}/restore", remove(http.HandlerFunc(ph.RestoreProduct))).Methods(http.MethodPost)
+	prodRouter.Use(login.AuthMiddleware)
 
+	// the permission is checked before the validation of the body
 	putPostRouter := prodRouter.Methods(http.MethodPost, http.MethodPut).Subrouter()
 	putPostRouter.HandleFunc("/{id:[0-9]+}", ph.UpdateProduct).Methods(http.MethodPut)
 	putPostRouter.HandleFunc("", ph.AddProduct).Methods(http.MethodPost)
-	putPostRouter.Use(ph.MiddlewareProductValidation)
+	putPostRouter.Use(write, ph.MiddlewareProductValidation)
+
+	// users sub router, only for the admin role
+	uh := handlers.NewUsers(a.Users, hasher)
+	userRouter := a.Router.PathPrefix("/users").Subrouter()
+	userRouter.HandleFunc("", uh.GetUsers).Methods(http.MethodGet)
+	userRouter.HandleFunc("", uh.AddUser).Methods(http.MethodPost)
+	userRouter.HandleFunc("/{id:[0-9]+}", uh.GetUser).Methods(http.MethodGet)
+	userRouter.HandleFunc("/{id:[0-9]+}", uh.UpdateUser).Methods(http.MethodPut)
+	userRouter.HandleFunc("/{id:[0-9]+}", uh.DeleteUser).Methods(http.MethodDelete)
+	userRouter.HandleFunc("/{id:[0-9]+}/enable", uh.EnableUser).Methods(http.MethodPost)
+	userRouter.HandleFunc("/{id:[0-9]+}/disable", uh.DisableUser).Methods(http.MethodPost)
+	userRouter.Use(login.AuthMiddleware, handlers.RequireRole(security.RoleAdmin))
+
+	// api keys sub router, every user manages its own keys with an access token
+	kh := handlers.NewApiKeys(a.ApiKeys, a.Users)
+	keyRouter := a.Router.PathPrefix("/api-keys").Subrouter()
+	keyRouter.HandleFunc("", kh.GetApiKeys).Methods(http.MethodGet)
+	keyRouter.HandleFunc("", kh.AddApiKey).Methods(http.MethodPost)
+	keyRouter.HandleFunc("/{id:[0-9]+}/rotate", kh.RotateApiKey).Methods(http.MethodPost)
+	keyRouter.HandleFunc("/{id:[0-9]+}", kh.RevokeApiKey).Methods(http.MethodDelete)
+	keyRouter.Use(login.AuthMiddleware, handlers.RequireBearer)
 
-	// login handler
-	login := handlers.NewLogin(a.DBPool)
+	// login, refresh and logout
 	a.Router.HandleFunc("/login", login.Login).Methods(http.MethodPost)
+	a.Router.HandleFunc("/token/refresh", login.Refresh).Methods(http.MethodPost)
+	a.Router.Handle("/logout", login.AuthMiddleware(handlers.RequireBearer(http.HandlerFunc(login.Logout)))).
+		Methods(http.MethodPost)
+	// probes of the orchestrator
+	a.Router.Handle("/healthz", health.LiveHandler()).Methods(http.MethodGet)
+	a.Router.Handle("/readyz", a.Health.ReadyHandler()).Methods(http.MethodGet)
+	// public keys to verify the tokens
+	a.Router.HandleFunc("/.well-known/jwks.json", login.JWKS).Methods(http.MethodGet)
 
 	// doc part
 	opts := middleware.RedocOpts{
-		SpecURL:  "/static/openapi.yaml",
+		SpecURL: "/static/openapi.yaml",
 	}
 	sh := middleware.Redoc(opts, nil)
 	a.Router.Handle("/docs", sh)
@@ -150,34 +451,10 @@ func commonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func loadConfig(){
-	// does the env variable exist?
-	if len(os.Getenv("APP_CONFIG")) > 0{
-		ok, err := fs.ExistsPath(os.Getenv("APP_CONFIG"))
-		output.CheckErrorAndExit("", "", err)
-		if ok{
-			// load the config file
-			err = fs.ReadYaml(os.Getenv("APP_CONFIG"), &utils.Server)
-			output.CheckErrorAndExit("", "", err)
-			return
-		}else{
-			output.ErrorLog("", "an error occurred during the load of the configuration file: " +
-				"APP_CONFIG points to a wrong path")
-			os.Exit(1)
-		}
+// chain wraps h with the middlewares, the first one is the outermost
+func chain(h http.Handler, middlewares ...mux.MiddlewareFunc) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
 	}
-
-	// read from the default location
-	ok, err := fs.ExistsPath("config/server.yml")
-	output.CheckErrorAndExit("", "", err)
-	if ok{
-		// load the config file
-		err = fs.ReadYaml("config/server.yml", &utils.Server)
-		output.CheckErrorAndExit("", "", err)
-		return
-	}else{
-		output.ErrorLog("", "an error occurred during the load of the configuration file: config/server.yml doesn't exist")
-		os.Exit(1)
-	}
-
-}
\ No newline at end of file
+	return h
+}
@@ -14,7 +14,6 @@ docker network create --attachable rest-api-test
 
 printf "${SUB_ACT} starting postgresql container...${STOP_COLOR}\n"
 docker run --rm -d -p 5432:5432 -e POSTGRES_PASSWORD=password \
--v "${PWD}"/scripts/db:/docker-entrypoint-initdb.d/ \
 --network rest-api-test \
 --name postgres_test postgres:13.2-alpine
 # wait some time to load postgresql
@@ -30,6 +29,6 @@ docker run --rm -it \
 -e APP_CONFIG=/usr/local/rest-api/config/server.yml \
 -v "${PWD}":/usr/local/rest-api \
 --network rest-api-test \
---name rest-api-test golang:1.16.5-buster go test github.com/mas2020-golang/rest-api/test/...
+--name rest-api-test golang:1.25-bookworm go test github.com/mas2020-golang/rest-api/test/...
 
 
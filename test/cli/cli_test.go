@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/cli"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/server"
+	"github.com/mas2020-golang/rest-api/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv("APP_CONFIG")) == 0 {
+		os.Setenv("APP_CONFIG", "../../config/server.yml")
+	}
+	os.Exit(m.Run())
+}
+
+// result is the outcome of a command
+type result struct {
+	code           int
+	stdout, stderr string
+}
+
+// run executes the command on the in memory users, stdin is the standard input
+func run(users models.UserRepository, stdin string, args ...string) result {
+	var stdout, stderr bytes.Buffer
+	c := &cli.CLI{Stdin: strings.NewReader(stdin), Stdout: &stdout, Stderr: &stderr,
+		Open: func(loader *utils.Loader) *server.App {
+			a := &server.App{Loader: loader, LogOutput: io.Discard}
+			a.InitializeWithRepository(models.NewMemProducts(), users, models.NewMemTokens(), models.NewMemApiKeys())
+			return a
+		}}
+	code := c.Run(args)
+	return result{code, stdout.String(), stderr.String()}
+}
+
+// expect fails the test if the command didn't exit with code
+func expect(t *testing.T, r result, code int) {
+	t.Helper()
+	if r.code != code {
+		t.Fatalf("expected the exit code %d, got %d\nstdout: %s\nstderr: %s", code, r.code, r.stdout, r.stderr)
+	}
+}
+
+// checkPassword fails the test if password is not the one of the user
+func checkPassword(t *testing.T, users models.UserRepository, username, password string) *models.User {
+	t.Helper()
+	user, err := users.GetByUsername(context.Background(), username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := security.VerifyPassword(security.NewArgon2id(), password, user.ApiKey); !ok || err != nil {
+		t.Errorf("the password of %s is not '%s' (%v)", username, password, err)
+	}
+	return user
+}
+
+func TestUserCommands(t *testing.T) {
+	users := models.NewMemUsers()
+
+	r := run(users, "", "user", "create", "alice", "--type=editor", "--email", "alice@example.com",
+		"--password=secret")
+	expect(t, r, cli.ExitOK)
+	user := checkPassword(t, users, "alice", "secret")
+	if user.UserType != "editor" || user.Email != "alice@example.com" || user.Disabled {
+		t.Errorf("unexpected user %+v", user)
+	}
+	expect(t, run(users, "", "user", "create", "alice", "--password=secret"), cli.ExitFailure)
+	expect(t, run(users, "", "user", "create", "bob", "--type=boss"), cli.ExitUsage)
+	expect(t, run(users, "", "user", "create"), cli.ExitUsage)
+
+	// without a password a random one is printed
+	r = run(users, "", "user", "create", "bob")
+	expect(t, r, cli.ExitOK)
+	var generated string
+	fmt.Sscanf(r.stdout, "generated password: %s", &generated)
+	checkPassword(t, users, "bob", generated)
+
+	// disable and enable
+	expect(t, run(users, "", "user", "disable", "alice"), cli.ExitOK)
+	if user, _ = users.GetByUsername(context.Background(), "alice"); !user.Disabled {
+		t.Errorf("alice must be disabled")
+	}
+	expect(t, run(users, "", "user", "disable", "--enable", "alice"), cli.ExitOK)
+	if user, _ = users.GetByUsername(context.Background(), "alice"); user.Disabled {
+		t.Errorf("alice must be enabled")
+	}
+	expect(t, run(users, "", "user", "disable", "nobody"), cli.ExitFailure)
+
+	// the reset unlocks the user
+	until := time.Now().Add(time.Hour)
+	users.AddLoginFailure(context.Background(), user.ID)
+	users.SetLockedUntil(context.Background(), user.ID, &until, false)
+	expect(t, run(users, "new secret\n", "user", "reset-password", "alice", "--password-stdin"), cli.ExitOK)
+	user = checkPassword(t, users, "alice", "new secret")
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("alice must be unlocked, got %+v", user)
+	}
+	expect(t, run(users, "", "user", "reset-password", "alice", "--password-stdin"), cli.ExitFailure)
+	expect(t, run(users, "", "user", "remove", "alice"), cli.ExitUsage)
+}
+
+// writeConfig writes a configuration with an Ed25519 signing key and returns its path and the key set
+func writeConfig(t *testing.T) (string, *security.KeySet) {
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.pem")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	path := filepath.Join(dir, "server.yml")
+	os.WriteFile(path, []byte(fmt.Sprintf("token:\n  keys:\n    - id: test\n      algorithm: EdDSA\n"+
+		"      private_key: %s\n", keyPath)), 0600)
+
+	keys, err := security.LoadKeySet([]security.KeyConfig{{ID: "test", Algorithm: security.EdDSA,
+		PrivateKey: keyPath}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, keys
+}
+
+func TestTokenIssue(t *testing.T) {
+	users := models.NewMemUsers()
+	expect(t, run(users, "", "user", "create", "alice", "--type=admin", "--password=secret"), cli.ExitOK)
+	config, keys := writeConfig(t)
+
+	r := run(users, "", "token", "issue", "--config", config, "--user", "alice", "--ttl", "1h")
+	expect(t, r, cli.ExitOK)
+	claims := jwt.MapClaims{}
+	if _, err := keys.Parse(strings.TrimSpace(r.stdout), claims); err != nil {
+		t.Fatalf("the token is not valid: %s", err)
+	}
+	exp := time.Unix(int64(claims["exp"].(float64)), 0)
+	if claims["name"] != "alice" || claims["role"] != security.RoleAdmin || claims["typ"] != "access" ||
+		time.Until(exp) < 59*time.Minute || time.Until(exp) > time.Hour {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	expect(t, run(users, "", "token", "issue", "--config", config), cli.ExitUsage)
+	expect(t, run(users, "", "token", "issue", "--config", config, "--user", "nobody"), cli.ExitFailure)
+	// a random key would sign a token refused by the server
+	expect(t, run(users, "", "token", "issue", "--user", "alice"), cli.ExitFailure)
+	// a disabled user can't have tokens
+	expect(t, run(users, "", "user", "disable", "alice"), cli.ExitOK)
+	expect(t, run(users, "", "token", "issue", "--config", config, "--user", "alice"), cli.ExitFailure)
+}
+
+func TestConfigValidate(t *testing.T) {
+	r := run(nil, "", "config", "validate")
+	expect(t, r, cli.ExitOK)
+	if !strings.Contains(r.stdout, "is valid") {
+		t.Errorf("unexpected output %s", r.stdout)
+	}
+
+	path := filepath.Join(t.TempDir(), "server.yml")
+	os.WriteFile(path, []byte("http:\n  adress: \":8000\"\n"), 0600)
+	r = run(nil, "", "config", "validate", "--config", path)
+	expect(t, r, cli.ExitFailure)
+	if !strings.Contains(r.stderr, "unknown key 'adress'") {
+		t.Errorf("expected the unknown key in %s", r.stderr)
+	}
+	expect(t, run(nil, "", "config", "validate", "--database.sslmode=maybe"), cli.ExitFailure)
+	expect(t, run(nil, "", "config", "validate", "--http.adress=:8000"), cli.ExitUsage)
+}
+
+func TestUsage(t *testing.T) {
+	r := run(nil, "", "help")
+	expect(t, r, cli.ExitOK)
+	if !strings.Contains(r.stdout, "token issue") {
+		t.Errorf("unexpected usage %s", r.stdout)
+	}
+	expect(t, run(nil, "", "deploy"), cli.ExitUsage)
+	expect(t, run(nil, "", "migrate"), cli.ExitUsage)
+	expect(t, run(nil, "", "migrate", "sideways"), cli.ExitUsage)
+	expect(t, run(nil, "", "migrate", "down", "zero"), cli.ExitUsage)
+	expect(t, run(nil, "", "serve", "now"), cli.ExitUsage)
+	r = run(nil, "", "user", "create", "-h")
+	expect(t, r, cli.ExitOK)
+	if !strings.Contains(r.stderr, "-password-stdin") {
+		t.Errorf("the help must list the flags, got %s", r.stderr)
+	}
+	// the migrations need the database
+	expect(t, run(models.NewMemUsers(), "", "migrate", "status"), cli.ExitFailure)
+}
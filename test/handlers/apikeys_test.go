@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiKey is the body returned when a key is created or rotated
+type apiKey struct {
+	ID        int        `json:"key-id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key"`
+	ExpiresOn *time.Time `json:"expires-on"`
+}
+
+// apiKeyRequest calls the /api-keys resources with the given access token
+func apiKeyRequest(method, url, accessToken string, body io.Reader) (int, apiKey, string) {
+	if body == nil {
+		body = http.NoBody
+	}
+	req, _ := http.NewRequest(method, url, body)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	response := executeRequest(req)
+	var key apiKey
+	json.Unmarshal(response.Body.Bytes(), &key)
+	return response.Code, key, response.Body.String()
+}
+
+// getProductsWithKey calls GET /products with the given api key returning the response code
+func getProductsWithKey(key string) int {
+	req, _ := http.NewRequest("GET", "/products", nil)
+	req.Header.Set("X-API-Key", key)
+	return executeRequest(req).Code
+}
+
+func TestApiKeys(t *testing.T) {
+	tk := login(t)
+	code, key, body := apiKeyRequest("POST", "/api-keys", tk.Token, strings.NewReader(`{"name": "nightly import"}`))
+	checkResponseCode(t, http.StatusCreated, code)
+	if len(key.Key) == 0 || !strings.HasPrefix(key.Key, key.Prefix) || key.Name != "nightly import" {
+		t.Fatalf("unexpected key %s", body)
+	}
+	checkResponseCode(t, http.StatusOK, getProductsWithKey(key.Key))
+
+	// the list shows only the prefix
+	code, _, body = apiKeyRequest("GET", "/api-keys", tk.Token, nil)
+	checkResponseCode(t, http.StatusOK, code)
+	if !strings.Contains(body, key.Prefix) || strings.Contains(body, key.Key) || strings.Contains(body, "hash") {
+		t.Errorf("the list must show only the prefix: %s", body)
+	}
+
+	// the key authenticates as its user, with its role
+	req, _ := http.NewRequest("PATCH", "/products/1", strings.NewReader(`{"description": "changed"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("X-API-Key", key.Key)
+	if response := executeRequest(req); response.Code == http.StatusUnauthorized || response.Code == http.StatusForbidden {
+		t.Errorf("the editor api key must be allowed to change the products, got %d", response.Code)
+	}
+	req, _ = http.NewRequest("DELETE", "/products/1", nil)
+	req.Header.Set("X-API-Key", key.Key)
+	checkResponseCode(t, http.StatusForbidden, executeRequest(req).Code)
+	req, _ = http.NewRequest("GET", "/users", nil)
+	req.Header.Set("X-API-Key", key.Key)
+	checkResponseCode(t, http.StatusForbidden, executeRequest(req).Code)
+
+	// rotate: the old key stops working
+	code, rotated, body := apiKeyRequest("POST", "/api-keys/"+strconv.Itoa(key.ID)+"/rotate", tk.Token, nil)
+	checkResponseCode(t, http.StatusOK, code)
+	if rotated.ID != key.ID || rotated.Key == key.Key {
+		t.Fatalf("unexpected rotated key %s", body)
+	}
+	checkResponseCode(t, http.StatusUnauthorized, getProductsWithKey(key.Key))
+	checkResponseCode(t, http.StatusOK, getProductsWithKey(rotated.Key))
+
+	// revoke
+	code, _, _ = apiKeyRequest("DELETE", "/api-keys/"+strconv.Itoa(key.ID), tk.Token, nil)
+	checkResponseCode(t, http.StatusNoContent, code)
+	checkResponseCode(t, http.StatusUnauthorized, getProductsWithKey(rotated.Key))
+	code, _, _ = apiKeyRequest("DELETE", "/api-keys/"+strconv.Itoa(key.ID), tk.Token, nil)
+	checkResponseCode(t, http.StatusNotFound, code)
+	code, _, _ = apiKeyRequest("POST", "/api-keys/"+strconv.Itoa(key.ID)+"/rotate", tk.Token, nil)
+	checkResponseCode(t, http.StatusNotFound, code)
+}
+
+func TestApiKeysWrongRequests(t *testing.T) {
+	tk := login(t)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	bodies := []string{``, `{}`, `{"name": "job", "expires-on": "` + past + `"}`, `{"name": "job", "other": 1}`}
+	for _, b := range bodies {
+		code, _, _ := apiKeyRequest("POST", "/api-keys", tk.Token, strings.NewReader(b))
+		checkResponseCode(t, http.StatusBadRequest, code)
+	}
+	for _, k := range []string{"wrong", "rak_000000000000_wrong", "rak_"} {
+		checkResponseCode(t, http.StatusUnauthorized, getProductsWithKey(k))
+	}
+
+	// the keys of another user are not visible
+	code, key, _ := apiKeyRequest("POST", "/api-keys", tk.Token, strings.NewReader(`{"name": "job"}`))
+	checkResponseCode(t, http.StatusCreated, code)
+	code, _, _ = apiKeyRequest("DELETE", "/api-keys/"+strconv.Itoa(key.ID), token, nil)
+	checkResponseCode(t, http.StatusNotFound, code)
+
+	// an api key can't manage the api keys nor logout
+	for _, url := range []string{"/api-keys", "/logout"} {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(`{"name": "job"}`))
+		req.Header.Set("X-API-Key", key.Key)
+		checkResponseCode(t, http.StatusForbidden, executeRequest(req).Code)
+	}
+}
+
+func TestApiKeyExpiration(t *testing.T) {
+	tk := login(t)
+	expiresOn := time.Now().Add(time.Second).Format(time.RFC3339Nano)
+	code, key, _ := apiKeyRequest("POST", "/api-keys", tk.Token,
+		strings.NewReader(`{"name": "short", "expires-on": "`+expiresOn+`"}`))
+	checkResponseCode(t, http.StatusCreated, code)
+	checkResponseCode(t, http.StatusOK, getProductsWithKey(key.Key))
+	time.Sleep(time.Until(*key.ExpiresOn) + 10*time.Millisecond)
+	checkResponseCode(t, http.StatusUnauthorized, getProductsWithKey(key.Key))
+}
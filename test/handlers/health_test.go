@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mas2020-golang/rest-api/health"
+	"net/http"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	// no authentication is required
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	checkResponseCode(t, http.StatusOK, executeRequest(req).Code)
+
+	// a dependency registers its check
+	a.Health.Register("queue", func(ctx context.Context) (string, error) {
+		return "", errors.New("not connected")
+	})
+	defer a.Health.Register("queue", func(ctx context.Context) (string, error) { return "", nil })
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusServiceUnavailable, rr.Code)
+	var report health.Report
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.Status != health.StatusDown || len(report.Checks) == 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"github.com/mas2020-golang/rest-api/handlers"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/security"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLockoutLogin returns a login handler with the given policies and a new user with the password "right-pwd"
+func newLockoutLogin(t *testing.T, username string, user, ip security.LoginPolicy) (*handlers.LoginResource,
+	*models.User) {
+	hasher, _ := security.NewPasswordHasher(security.BcryptAlgorithm, 4)
+	hash, _ := hasher.Hash("right-pwd")
+	u := &models.User{Username: username, ApiKey: hash, UserType: security.RoleReader}
+	if err := a.Users.Add(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(clearUsers)
+	login := handlers.NewLogin(a.Users, hasher, a.Tokens, a.Keys, a.ApiKeys)
+	login.SetLoginPolicies(user, ip, true)
+	return login, u
+}
+
+// tryLogin calls the login handler from the given client IP
+func tryLogin(login *handlers.LoginResource, ip, username, password string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/login",
+		strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+	req.Header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+	rr := httptest.NewRecorder()
+	login.Login(rr, req)
+	return rr
+}
+
+func TestLoginLockout(t *testing.T) {
+	login, user := newLockoutLogin(t, "brute",
+		security.LoginPolicy{MaxFailures: 3, Lockout: time.Hour},
+		security.LoginPolicy{MaxFailures: 100, Lockout: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		checkResponseCode(t, http.StatusUnauthorized, tryLogin(login, "192.0.2."+strconv.Itoa(i), "brute", "wrong").Code)
+	}
+	// locked: also the right password is refused
+	rr := tryLogin(login, "192.0.2.10", "brute", "right-pwd")
+	checkResponseCode(t, http.StatusTooManyRequests, rr.Code)
+	if retry, _ := strconv.Atoi(rr.Header().Get("Retry-After")); retry < 3590 || retry > 3600 {
+		t.Errorf("expected to retry in about 3600 seconds, got %s", rr.Header().Get("Retry-After"))
+	}
+	stored, _ := a.Users.Get(context.Background(), user.ID)
+	if stored.LockedUntil == nil || stored.FailedLogins != 0 {
+		t.Errorf("expected the user locked with the failures reset, got %v %d", stored.LockedUntil, stored.FailedLogins)
+	}
+
+	// the admin unlocks the user enabling it
+	code, _ := userRequest("POST", "/users/"+strconv.Itoa(user.ID)+"/enable", "")
+	checkResponseCode(t, http.StatusOK, code)
+	checkResponseCode(t, http.StatusCreated, tryLogin(login, "192.0.2.10", "brute", "right-pwd").Code)
+}
+
+func TestLoginBackoff(t *testing.T) {
+	login, user := newLockoutLogin(t, "slow",
+		security.LoginPolicy{MaxFailures: 5, Backoff: time.Second, Lockout: time.Hour},
+		security.LoginPolicy{MaxFailures: 100, Lockout: time.Hour})
+
+	checkResponseCode(t, http.StatusUnauthorized, tryLogin(login, "192.0.2.20", "slow", "wrong").Code)
+	rr := tryLogin(login, "192.0.2.20", "slow", "right-pwd")
+	checkResponseCode(t, http.StatusTooManyRequests, rr.Code)
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected to retry in 1 second, got %s", rr.Header().Get("Retry-After"))
+	}
+
+	// after the backoff the right password resets the failures
+	time.Sleep(time.Second)
+	checkResponseCode(t, http.StatusCreated, tryLogin(login, "192.0.2.20", "slow", "right-pwd").Code)
+	stored, _ := a.Users.Get(context.Background(), user.ID)
+	if stored.LockedUntil != nil || stored.FailedLogins != 0 {
+		t.Errorf("expected the failures reset, got %v %d", stored.LockedUntil, stored.FailedLogins)
+	}
+}
+
+func TestLoginIPThrottle(t *testing.T) {
+	login, _ := newLockoutLogin(t, "victim",
+		security.LoginPolicy{MaxFailures: 100, Lockout: time.Hour},
+		security.LoginPolicy{MaxFailures: 3, Lockout: time.Hour})
+
+	// the failures of the unknown users are counted too
+	for _, username := range []string{"nobody", "victim", "somebody"} {
+		checkResponseCode(t, http.StatusUnauthorized, tryLogin(login, "198.51.100.1", username, "wrong").Code)
+	}
+	rr := tryLogin(login, "198.51.100.1", "victim", "right-pwd")
+	checkResponseCode(t, http.StatusTooManyRequests, rr.Code)
+	if len(rr.Header().Get("Retry-After")) == 0 {
+		t.Errorf("expected the Retry-After header")
+	}
+	// another client is not blocked
+	checkResponseCode(t, http.StatusCreated, tryLogin(login, "198.51.100.2", "victim", "right-pwd").Code)
+}
+
+func TestLoginUnknownUserLockout(t *testing.T) {
+	login, _ := newLockoutLogin(t, "known",
+		security.LoginPolicy{MaxFailures: 3, Lockout: time.Hour},
+		security.LoginPolicy{MaxFailures: 100, Lockout: time.Hour})
+
+	// an existing and an unknown user get the same responses, the 429 doesn't tell which users exist
+	for _, username := range []string{"known", "unknown"} {
+		for i := 0; i < 3; i++ {
+			checkResponseCode(t, http.StatusUnauthorized,
+				tryLogin(login, "192.0.2."+strconv.Itoa(30+i), username, "wrong").Code)
+		}
+		rr := tryLogin(login, "192.0.2.40", username, "wrong")
+		checkResponseCode(t, http.StatusTooManyRequests, rr.Code)
+		if retry, _ := strconv.Atoi(rr.Header().Get("Retry-After")); retry < 3590 || retry > 3600 {
+			t.Errorf("%s: expected to retry in about 3600 seconds, got %s", username,
+				rr.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	clearTable()
+	req, _ := http.NewRequest("GET", "/products/1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	executeRequest(req)
+	req, _ = http.NewRequest("GET", "/not-a-route", nil)
+	executeRequest(req)
+
+	// the metrics are served only by the admin listener
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(req).Code)
+	rr := httptest.NewRecorder()
+	a.Admin.ServeHTTP(rr, req)
+	checkResponseCode(t, http.StatusOK, rr.Code)
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %s", ct)
+	}
+	body := rr.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/products/{id:[0-9]+}",status="404"}`,
+		`http_requests_total{method="GET",route="none",status="404"}`,
+		`http_request_duration_seconds_bucket{method="GET",route="/products/{id:[0-9]+}",status="404",le="+Inf"}`,
+		// the token of the tests is generated with a login
+		`auth_logins_total{result="success"}`,
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+}
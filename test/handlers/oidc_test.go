@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/handlers"
+	"github.com/mas2020-golang/rest-api/security"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestIdP starts a stand-in for an identity provider with a single RSA key, it returns the server and a function
+// signing the tokens with its key
+func newTestIdP(t *testing.T) (*httptest.Server, func(jwt.MapClaims) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(security.JWKS{Keys: []security.JWK{{Kty: "RSA", Kid: "idp", Alg: security.RS256,
+			Use: "sig", N: enc(key.N.Bytes()), E: enc(big.NewInt(int64(key.E)).Bytes())}}})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "idp"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+}
+
+func TestOIDCAuthMiddleware(t *testing.T) {
+	idp, sign := newTestIdP(t)
+	verifier, err := security.NewOIDCVerifier(security.OIDCConfig{
+		Issuer:    idp.URL,
+		Audience:  "rest-api",
+		RoleClaim: "groups",
+		Roles:     map[string]string{"api-editors": security.RoleEditor},
+	}, idp.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, _ := security.NewPasswordHasher(security.BcryptAlgorithm, 4)
+	login := handlers.NewLogin(a.Users, hasher, a.Tokens, a.Keys, a.ApiKeys)
+	login.SetOIDC(verifier)
+	// the handler returns the claims injected by the middleware
+	router := login.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Context().Value("claims"))
+	}))
+	call := func(token string) (int, jwt.MapClaims) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		claims := jwt.MapClaims{}
+		json.Unmarshal(rr.Body.Bytes(), &claims)
+		return rr.Code, claims
+	}
+	claims := func(aud string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                idp.URL,
+			"aud":                aud,
+			"sub":                "42",
+			"preferred_username": "jdoe",
+			"groups":             []string{"api-editors"},
+			"exp":                time.Now().Add(time.Minute).Unix(),
+		}
+	}
+
+	code, got := call(sign(claims("rest-api")))
+	checkResponseCode(t, http.StatusOK, code)
+	if got["name"] != "jdoe" || got["role"] != security.RoleEditor || got["typ"] != "access" {
+		t.Errorf("unexpected claims %v", got)
+	}
+	code, _ = call(sign(claims("other-api")))
+	checkResponseCode(t, http.StatusUnauthorized, code)
+
+	// the local tokens are still accepted
+	code, got = call(signToken(security.RoleReader))
+	checkResponseCode(t, http.StatusOK, code)
+	if got["role"] != security.RoleReader {
+		t.Errorf("unexpected claims %v", got)
+	}
+}
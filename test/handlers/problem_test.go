@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mas2020-golang/rest-api/problem"
+	"net/http"
+	"testing"
+)
+
+// decodeProblem checks that the response is a problem with the given status and returns it
+func decodeProblem(t *testing.T, status int, response *http.Response, body []byte) problem.Problem {
+	t.Helper()
+	checkResponseCode(t, status, response.StatusCode)
+	if ct := response.Header.Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("expected the Content-Type %s, got %s", problem.ContentType, ct)
+	}
+	var p problem.Problem
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("the body is not a problem: %s (%s)", err, body)
+	}
+	if p.Status != status || len(p.RequestID) == 0 || p.RequestID != response.Header.Get("X-Request-ID") {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	return p
+}
+
+func TestProblemDetails(t *testing.T) {
+	clearTable()
+
+	// product not valid
+	req, _ := http.NewRequest("POST", "/products", bytes.NewBufferString(`{"name": "", "price": -1, "sku": "abc"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := executeRequest(req)
+	p := decodeProblem(t, http.StatusBadRequest, rr.Result(), rr.Body.Bytes())
+	if p.Type != problem.TypePrefix+problem.KindValidation || len(p.Errors) != 3 {
+		t.Errorf("expected 3 field errors, got %+v", p)
+	}
+
+	// wrong JSON
+	req, _ = http.NewRequest("PUT", "/products/1", bytes.NewBufferString(`{"name": 1}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = executeRequest(req)
+	p = decodeProblem(t, http.StatusBadRequest, rr.Result(), rr.Body.Bytes())
+	if len(p.Errors) != 1 || p.Errors[0].Field != "name" {
+		t.Errorf("expected an error on name, got %+v", p)
+	}
+
+	// product not found, the request id of the caller is returned
+	req, _ = http.NewRequest("GET", "/products/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "test-42")
+	rr = executeRequest(req)
+	p = decodeProblem(t, http.StatusNotFound, rr.Result(), rr.Body.Bytes())
+	if p.RequestID != "test-42" || p.Instance != "/products/42" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+
+	// no token
+	req, _ = http.NewRequest("GET", "/products", nil)
+	rr = executeRequest(req)
+	decodeProblem(t, http.StatusUnauthorized, rr.Result(), rr.Body.Bytes())
+
+	// unknown route and method
+	req, _ = http.NewRequest("GET", "/unknown", nil)
+	rr = executeRequest(req)
+	decodeProblem(t, http.StatusNotFound, rr.Result(), rr.Body.Bytes())
+	req, _ = http.NewRequest("PATCH", "/login", nil)
+	rr = executeRequest(req)
+	decodeProblem(t, http.StatusMethodNotAllowed, rr.Result(), rr.Body.Bytes())
+}
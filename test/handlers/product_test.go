@@ -5,37 +5,50 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/handlers"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/metrics"
+	"github.com/mas2020-golang/rest-api/migrations"
 	"github.com/mas2020-golang/rest-api/models"
 	"github.com/mas2020-golang/rest-api/server"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var a server.App
 var token string
 
-const tableCreationQuery = `
-CREATE TABLE IF NOT EXISTS products
-(
-    id SERIAL,
-    name TEXT NOT NULL,
-    description TEXT NOT NULL,
-    sku varchar(100),
-    price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-    CONSTRAINT products_pkey PRIMARY KEY (id)
-)`
-
-func ensureTableExists() {
-	if _, err := a.DBPool.Exec(context.Background(), tableCreationQuery); err != nil {
+// memory and memoryUsers are the in memory repositories used when APP_DB_HOST is not set, in that case the tests
+// run without a PostgreSQL database
+var (
+	memory      *models.MemProducts
+	memoryUsers *models.MemUsers
+)
+
+// migrateDB creates the tables using the same migrations of the application
+func migrateDB() {
+	m, err := migrations.New(a.DBPool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func clearTable() {
+	if memory != nil {
+		memory.Reset()
+		return
+	}
 	a.DBPool.Exec(context.Background(), "DELETE FROM products")
 	a.DBPool.Exec(context.Background(), "ALTER SEQUENCE products_id_seq RESTART WITH 1")
 }
@@ -56,8 +69,8 @@ func checkResponseCode(t *testing.T, expected, actual int) {
 func generateToken() string {
 	buf := bytes.Buffer{}
 	body := `{
-"username": "andrea",
-"password": "my-andrea-pwd"
+"username": "root",
+"password": "my-root-pwd"
 }`
 	buf.Write([]byte(body))
 	req, _ := http.NewRequest("POST", "/login", &buf)
@@ -73,13 +86,19 @@ func generateToken() string {
 }
 
 func TestMain(m *testing.M) {
-	a.Initialize(
-		os.Getenv("APP_DB_USERNAME"),
-		os.Getenv("APP_DB_PASSWORD"),
-		os.Getenv("APP_DB_HOST"),
-		os.Getenv("APP_DB_NAME"))
-
-	ensureTableExists()
+	if len(os.Getenv("APP_CONFIG")) == 0 {
+		os.Setenv("APP_CONFIG", "../../config/server.yml")
+	}
+	if len(os.Getenv("APP_DB_HOST")) > 0 {
+		// the connection is read from the APP_DB_* variables
+		a.Initialize()
+		migrateDB()
+	} else {
+		memory = models.NewMemProducts()
+		memoryUsers = models.NewMemUsers()
+		a.InitializeWithRepository(memory, memoryUsers, models.NewMemTokens(), models.NewMemApiKeys())
+	}
+	seedUsers()
 	// get the token
 	token = generateToken()
 	// all the test are executed by calling m.Run()
@@ -104,8 +123,8 @@ func TestEmptyTable(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	response = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, response.Code)
-	if strings.Trim(response.Body.String(), "\n") != "null" {
-		t.Errorf("Expected body == null, got %v", response.Body.String())
+	if body := strings.Trim(response.Body.String(), "\n"); body != `{"items":[],"total_count":0}` {
+		t.Errorf("Expected an empty page, got %v", body)
 	}
 }
 
@@ -153,7 +172,7 @@ func TestGetProducts(t *testing.T) {
 			Price:       100 + float32(i),
 			SKU:         "dsda-asd-asd",
 		}
-		err := models.Products.Add(a.DBPool, &p)
+		err := a.Products.Add(context.Background(), &p)
 		if err != nil {
 			t.Error("error occurred during the product creation")
 		}
@@ -165,10 +184,87 @@ func TestGetProducts(t *testing.T) {
 	response := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, response.Code)
 	// check the content
-	var products []map[string]interface{}
-	json.Unmarshal(response.Body.Bytes(), &products)
-	if len(products) != 5 {
-		t.Errorf("Expected 5 products. Got %d", len(products))
+	var page struct {
+		Items      []map[string]interface{} `json:"items"`
+		TotalCount int                      `json:"total_count"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if len(page.Items) != 5 || page.TotalCount != 5 {
+		t.Errorf("Expected 5 products. Got %d of %d", len(page.Items), page.TotalCount)
+	}
+}
+
+func TestGetProductsPagination(t *testing.T) {
+	clearTable()
+	for i := 0; i < 5; i++ {
+		p := models.Product{
+			Name:        fmt.Sprintf("test-%d", i),
+			Description: fmt.Sprintf("test-%d", i),
+			Price:       100 + float32(i),
+			SKU:         "dsda-asd-asd",
+		}
+		if err := a.Products.Add(context.Background(), &p); err != nil {
+			t.Error("error occurred during the product creation")
+		}
+	}
+
+	type pageT struct {
+		Items      []map[string]interface{} `json:"items"`
+		NextCursor string                   `json:"next_cursor"`
+		TotalCount int                      `json:"total_count"`
+	}
+	// follow the cursors until the last page
+	var ids []float64
+	path := "/products?limit=2"
+	for i := 0; i < 5 && len(path) > 0; i++ {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+		var page pageT
+		json.Unmarshal(response.Body.Bytes(), &page)
+		if page.TotalCount != 5 {
+			t.Errorf("Expected total_count 5. Got %d", page.TotalCount)
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item["id"].(float64))
+		}
+		path = ""
+		if len(page.NextCursor) > 0 {
+			path = "/products?limit=2&cursor=" + page.NextCursor
+			if link := response.Header().Get("Link"); !strings.Contains(link, "cursor="+page.NextCursor) {
+				t.Errorf("Expected the next cursor in the Link header. Got %s", link)
+			}
+		}
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Errorf("Expected products [1 2 3 4 5]. Got %v", ids)
+	}
+
+	// offset pagination
+	req, _ := http.NewRequest("GET", "/products?limit=2&offset=2", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var page pageT
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if len(page.Items) != 2 || page.Items[0]["id"] != 3.0 {
+		t.Errorf("Expected products 3 and 4. Got %v", page.Items)
+	}
+	link := response.Header().Get("Link")
+	for _, rel := range []string{`offset=0>; rel="prev"`, `offset=4>; rel="next"`, `offset=4>; rel="last"`} {
+		if !strings.Contains(link, rel) {
+			t.Errorf("Expected %s in the Link header. Got %s", rel, link)
+		}
+	}
+
+	// wrong parameters
+	for _, query := range []string{"limit=0", "limit=abc", "offset=-1", "cursor=wrong", "offset=1&cursor=" +
+		page.NextCursor} {
+		req, _ := http.NewRequest("GET", "/products?"+query, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
 	}
 }
 
@@ -181,7 +277,7 @@ func TestUpdateProduct(t *testing.T) {
 		Price:       100,
 		SKU:         "dsda-asd-asd",
 	}
-	err := models.Products.Add(a.DBPool, &p)
+	err := a.Products.Add(context.Background(), &p)
 	if err != nil {
 		t.Error("error occurred during the product creation")
 	}
@@ -224,3 +320,445 @@ func TestUpdateProduct(t *testing.T) {
 			11.22, m["price"])
 	}
 }
+
+func TestDeleteProduct(t *testing.T) {
+	clearTable()
+	p := models.Product{
+		Name:        "test",
+		Description: "test",
+		Price:       100,
+		SKU:         "dsda-asd-asd",
+	}
+	err := a.Products.Add(context.Background(), &p)
+	if err != nil {
+		t.Error("error occurred during the product creation")
+	}
+
+	req, _ := http.NewRequest("DELETE", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, response.Code)
+
+	// a deleted product is not visible anymore
+	req, _ = http.NewRequest("GET", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("GET", "/products", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if body := strings.Trim(response.Body.String(), "\n"); body != `{"items":[],"total_count":0}` {
+		t.Errorf("Expected an empty page, got %v", body)
+	}
+
+	// unless the include_deleted option is used
+	req, _ = http.NewRequest("GET", "/products/1?include_deleted=true", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m["deleted_on"] == nil {
+		t.Errorf("Expected deleted_on to be set. Got '%v'", m["deleted_on"])
+	}
+
+	// the second delete doesn't find the product
+	req, _ = http.NewRequest("DELETE", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestRestoreProduct(t *testing.T) {
+	clearTable()
+	p := models.Product{
+		Name:        "test",
+		Description: "test",
+		Price:       100,
+		SKU:         "dsda-asd-asd",
+	}
+	err := a.Products.Add(context.Background(), &p)
+	if err != nil {
+		t.Error("error occurred during the product creation")
+	}
+
+	// a product not deleted can't be restored
+	req, _ := http.NewRequest("POST", "/products/1/restore", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	err = a.Products.Delete(context.Background(), p.ID)
+	if err != nil {
+		t.Error("error occurred during the product deletion")
+	}
+	req, _ = http.NewRequest("POST", "/products/1/restore", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestGetProductsFilters(t *testing.T) {
+	clearTable()
+	for _, p := range []models.Product{
+		{Name: "Espresso", Description: "short and strong coffee", Price: 2.5, SKU: "cof-esp-one"},
+		{Name: "Cappuccino", Description: "coffee with milk", Price: 3, SKU: "cof-cap-one"},
+		{Name: "Green tea", Description: "hot tea", Price: 2.5, SKU: "tea-gre-one"},
+		{Name: "Espresso doppio", Description: "double coffee", Price: 4, SKU: "cof-esp-two"},
+	} {
+		p := p
+		if err := a.Products.Add(context.Background(), &p); err != nil {
+			t.Error("error occurred during the product creation")
+		}
+	}
+
+	tests := []struct {
+		query string
+		ids   string
+	}{
+		{"price_min=2.5&price_max=3", "[1 2 3]"},
+		{"sku=cof-esp-one", "[1]"},
+		{"sku_prefix=cof-esp", "[1 4]"},
+		{"name=ESPRESSO", "[1 4]"},
+		{"name=%25", "[]"},
+		{"q=strong%20coffee", "[1]"},
+		{"sort=-price,name", "[4 2 1 3]"},
+		{"sku_prefix=cof&sort=-name", "[4 1 2]"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/products?"+test.query, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+		var page struct {
+			Items []map[string]interface{} `json:"items"`
+		}
+		json.Unmarshal(response.Body.Bytes(), &page)
+		ids := []float64{}
+		for _, item := range page.Items {
+			ids = append(ids, item["id"].(float64))
+		}
+		if fmt.Sprint(ids) != test.ids {
+			t.Errorf("%s: expected products %s. Got %v", test.query, test.ids, ids)
+		}
+	}
+
+	// wrong parameters
+	for _, query := range []string{"price_min=abc", "price_min=5&price_max=1", "sort=description", "sort=-"} {
+		req, _ := http.NewRequest("GET", "/products?"+query, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestPatchProduct(t *testing.T) {
+	clearTable()
+	p := models.Product{
+		Name:        "test",
+		Description: "test",
+		Price:       100,
+		SKU:         "dsda-asd-asd",
+	}
+	if err := a.Products.Add(context.Background(), &p); err != nil {
+		t.Error("error occurred during the product creation")
+	}
+
+	tests := []struct {
+		contentType string
+		body        string
+		code        int
+		price       float64
+		name        string
+	}{
+		{"application/merge-patch+json", `{"price": 11.5}`, http.StatusOK, 11.5, "test"},
+		{"application/json-patch+json", `[{"op": "test", "path": "/price", "value": 11.5},
+			{"op": "replace", "path": "/name", "value": "patched"}]`, http.StatusOK, 11.5, "patched"},
+		{"application/json-patch+json", `[{"op": "test", "path": "/price", "value": 1},
+			{"op": "replace", "path": "/name", "value": "wrong"}]`, http.StatusConflict, 11.5, "patched"},
+		{"application/merge-patch+json", `{"price": -1}`, http.StatusBadRequest, 11.5, "patched"},
+		{"application/merge-patch+json", `{"name": null}`, http.StatusBadRequest, 11.5, "patched"},
+		{"application/merge-patch+json", `{"id": 2}`, http.StatusBadRequest, 11.5, "patched"},
+		{"application/merge-patch+json", `{"unknown": 2}`, http.StatusBadRequest, 11.5, "patched"},
+		{"application/json-patch+json", `{"price": 1}`, http.StatusBadRequest, 11.5, "patched"},
+		{"application/json", `{"price": 1}`, http.StatusUnsupportedMediaType, 11.5, "patched"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("PATCH", "/products/1", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response := executeRequest(req)
+		checkResponseCode(t, test.code, response.Code)
+
+		stored, err := a.Products.Get(context.Background(), 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if float64(stored.Price) != test.price || stored.Name != test.name || stored.SKU != "dsda-asd-asd" {
+			t.Errorf("%s: expected name '%s' and price %v. Got %#v", test.body, test.name, test.price, stored)
+		}
+	}
+
+	req, _ := http.NewRequest("PATCH", "/products/2", strings.NewReader(`{"price": 1}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestProductETag(t *testing.T) {
+	clearTable()
+	p := models.Product{
+		Name:        "test",
+		Description: "test",
+		Price:       100,
+		SKU:         "dsda-asd-asd",
+	}
+	if err := a.Products.Add(context.Background(), &p); err != nil {
+		t.Error("error occurred during the product creation")
+	}
+
+	req, _ := http.NewRequest("GET", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	etag := response.Header().Get("ETag")
+	if len(etag) == 0 || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Expected a strong ETag. Got '%s'", etag)
+	}
+
+	// the client has already the current representation
+	req, _ = http.NewRequest("GET", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-None-Match", etag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotModified, response.Code)
+	if response.Body.Len() != 0 {
+		t.Errorf("Expected an empty body. Got %s", response.Body.String())
+	}
+
+	// first update using the current ETag wins
+	req, _ = http.NewRequest("PUT", "/products/1",
+		strings.NewReader(`{"name":"first", "price": 11.22,"sku": "dfr-fadf-adfa"}`))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-Match", etag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNoContent, response.Code)
+	newETag := response.Header().Get("ETag")
+	if newETag == etag || len(newETag) == 0 {
+		t.Errorf("Expected a new ETag after the update. Got '%s'", newETag)
+	}
+
+	// second update using the old ETag fails both with PUT and PATCH
+	req, _ = http.NewRequest("PUT", "/products/1",
+		strings.NewReader(`{"name":"second", "price": 11.22,"sku": "dfr-fadf-adfa"}`))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-Match", etag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusPreconditionFailed, response.Code)
+
+	req, _ = http.NewRequest("PATCH", "/products/1", strings.NewReader(`{"name":"second"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-Match", etag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusPreconditionFailed, response.Code)
+
+	// the old ETag doesn't match anymore
+	req, _ = http.NewRequest("GET", "/products/1", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-None-Match", etag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m["name"] != "first" {
+		t.Errorf("Expected the name of the first update. Got '%v'", m["name"])
+	}
+
+	// PATCH with the current ETag
+	req, _ = http.NewRequest("PATCH", "/products/1", strings.NewReader(`{"name":"patched"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("If-Match", newETag)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if response.Header().Get("ETag") == newETag {
+		t.Errorf("Expected a new ETag after the patch. Got '%s'", response.Header().Get("ETag"))
+	}
+}
+
+func TestBulkImportProducts(t *testing.T) {
+	clearTable()
+	csv := "name,description,price,sku\n" +
+		"Espresso,short coffee,2.5,cof-esp-one\n" +
+		"\"Cappuccino, large\",coffee with milk,3,cof-cap-one\n" +
+		"Wrong price,,abc,cof-wro-one\n" +
+		"Wrong sku,,1,wrong\n"
+
+	// atomic mode: nothing is stored
+	req, _ := http.NewRequest("POST", "/products:bulk", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	var report struct {
+		Received int `json:"received"`
+		Inserted int `json:"inserted"`
+		Errors   []struct {
+			Row int `json:"row"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &report)
+	if report.Received != 4 || report.Inserted != 0 || len(report.Errors) != 2 || report.Errors[0].Row != 3 ||
+		report.Errors[1].Row != 4 {
+		t.Errorf("Expected 4 rows received, 0 inserted and errors on rows 3 and 4. Got %s", response.Body.String())
+	}
+	page, _ := a.Products.GetAll(context.Background(), models.ProductQuery{})
+	if page.TotalCount != 0 {
+		t.Errorf("Expected no products. Got %d", page.TotalCount)
+	}
+
+	// best-effort mode: the valid rows are stored
+	req, _ = http.NewRequest("POST", "/products:bulk?mode=best-effort", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &report)
+	if report.Inserted != 2 || len(report.Errors) != 2 {
+		t.Errorf("Expected 2 rows inserted and 2 errors. Got %s", response.Body.String())
+	}
+
+	// NDJSON
+	ndjson := `{"name": "Green tea", "description": "hot tea", "price": 2.5, "sku": "tea-gre-one"}
+
+{"name": "Black tea", "price": 2, "sku": "tea-bla-one"}
+`
+	req, _ = http.NewRequest("POST", "/products:bulk", strings.NewReader(ndjson))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+	page, _ = a.Products.GetAll(context.Background(), models.ProductQuery{Sort: []models.SortField{{Column: "name"}}})
+	var names []string
+	for _, p := range page.Items {
+		names = append(names, p.Name)
+	}
+	if fmt.Sprint(names) != "[Black tea Cappuccino, large Espresso Green tea]" {
+		t.Errorf("Expected 4 products. Got %v", names)
+	}
+
+	// wrong requests
+	for _, test := range []struct {
+		path        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"/products:bulk", "application/json", ndjson, http.StatusUnsupportedMediaType},
+		{"/products:bulk", "text/csv", "name,color\nEspresso,black\n", http.StatusBadRequest},
+		{"/products:bulk", "text/csv", "", http.StatusBadRequest},
+		{"/products:bulk?mode=all", "text/csv", csv, http.StatusBadRequest},
+	} {
+		req, _ = http.NewRequest("POST", test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		req.Header.Add("Authorization", "Bearer "+token)
+		response = executeRequest(req)
+		checkResponseCode(t, test.code, response.Code)
+	}
+}
+
+func TestExportProducts(t *testing.T) {
+	clearTable()
+	for _, p := range []models.Product{
+		{Name: "Espresso", Description: "short, strong", Price: 2.5, SKU: "cof-esp-one"},
+		{Name: "Cappuccino", Description: "coffee with milk", Price: 3, SKU: "cof-cap-one"},
+	} {
+		p := p
+		a.Products.Add(context.Background(), &p)
+	}
+
+	req, _ := http.NewRequest("GET", "/products/export?format=csv", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if ct := response.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Expected Content-Type text/csv. Got %s", ct)
+	}
+	expected := "id,name,description,price,sku\n1,Espresso,\"short, strong\",2.5,cof-esp-one\n" +
+		"2,Cappuccino,coffee with milk,3,cof-cap-one\n"
+	if response.Body.String() != expected {
+		t.Errorf("Expected the CSV\n%s\nGot\n%s", expected, response.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/products/export", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"name":"Cappuccino"`) {
+		t.Errorf("Expected 2 NDJSON lines. Got %v", lines)
+	}
+
+	// the exported CSV can be imported again
+	clearTable()
+	req, _ = http.NewRequest("POST", "/products:bulk", strings.NewReader(expected))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Add("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+}
+
+// slowProducts is a repository walking the products slowly, like a big table read from a busy database
+type slowProducts struct {
+	models.ProductRepository
+	delay time.Duration
+}
+
+func (s *slowProducts) Walk(ctx context.Context, includeDeleted bool, fn func(prod *models.Product) error) error {
+	return s.ProductRepository.Walk(ctx, includeDeleted, func(prod *models.Product) error {
+		time.Sleep(s.delay)
+		return fn(prod)
+	})
+}
+
+// TestExportProductsWriteTimeout streams an export lasting longer than the write timeout of the server through the
+// middlewares wrapping the writer: the deadline is extended while the rows are written
+func TestExportProductsWriteTimeout(t *testing.T) {
+	repo := models.NewMemProducts()
+	for i := 0; i < 6; i++ {
+		repo.Add(context.Background(), &models.Product{Name: fmt.Sprintf("Tea %d", i), Description: "green tea",
+			Price: 2.5, SKU: "tea-tea-tea"})
+	}
+	const timeout = 200 * time.Millisecond
+	ph := handlers.NewProducts(&slowProducts{ProductRepository: repo, delay: timeout / 2})
+	ph.SetWriteTimeout(timeout)
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware("test"), logging.AccessLog, metrics.Middleware)
+	r.HandleFunc("/products/export", ph.ExportProducts)
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.WriteTimeout = timeout
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/products/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("the export has been interrupted: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 6 {
+		t.Errorf("Expected 6 NDJSON lines. Got %d", len(lines))
+	}
+}
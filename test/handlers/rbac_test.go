@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRolesPermissions(t *testing.T) {
+	clearTable()
+	err := a.Products.Add(context.Background(), &models.Product{Name: "test", Description: "test", Price: 10,
+		SKU: "abc-abc-abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	product := `{"name": "test", "description": "test", "price": 10, "sku": "abc-abc-abc"}`
+
+	tests := []struct {
+		role, method, url, body string
+		code                    int
+	}{
+		{"reader", "GET", "/products", "", http.StatusOK},
+		{"reader", "GET", "/products/1", "", http.StatusOK},
+		{"reader", "GET", "/products/export", "", http.StatusOK},
+		{"reader", "POST", "/products", product, http.StatusForbidden},
+		{"reader", "PUT", "/products/1", product, http.StatusForbidden},
+		{"reader", "PATCH", "/products/1", `{"name": "x"}`, http.StatusForbidden},
+		{"reader", "DELETE", "/products/1", "", http.StatusForbidden},
+		{"reader", "POST", "/products/1/restore", "", http.StatusForbidden},
+		{"reader", "POST", "/products:bulk", "", http.StatusForbidden},
+		{"reader", "GET", "/users", "", http.StatusForbidden},
+		{"editor", "POST", "/products", product, http.StatusCreated},
+		{"editor", "PUT", "/products/1", product, http.StatusNoContent},
+		{"editor", "DELETE", "/products/1", "", http.StatusForbidden},
+		{"editor", "POST", "/products/1/restore", "", http.StatusForbidden},
+		{"editor", "GET", "/products?include_deleted=true", "", http.StatusForbidden},
+		{"editor", "GET", "/users", "", http.StatusForbidden},
+		{"admin", "GET", "/products?include_deleted=true", "", http.StatusOK},
+		{"admin", "GET", "/users", "", http.StatusOK},
+		{"unknown", "GET", "/products", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+		req.Header.Set("Authorization", "Bearer "+signToken(test.role))
+		if test.method == "PATCH" {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+		if code := executeRequest(req).Code; code != test.code {
+			t.Errorf("%s %s %s: expected %d, got %d", test.role, test.method, test.url, test.code, code)
+		}
+	}
+}
+
+func TestTokenRoleClaim(t *testing.T) {
+	roles := map[string]string{"root": "admin", "andrea": "editor"}
+	for username, role := range roles {
+		password := "my-" + username + "-pwd"
+		req, _ := http.NewRequest("POST", "/login",
+			strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, response.Code)
+		var m map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &m)
+		claims := jwt.MapClaims{}
+		_, err := a.Keys.Parse(m["token"].(string), claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims["role"] != role {
+			t.Errorf("%s: expected the role %s, got %v", username, role, claims["role"])
+		}
+	}
+}
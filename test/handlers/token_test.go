@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// tokens is the body returned by /login and /token/refresh
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// login returns the tokens of the andrea user
+func login(t *testing.T) tokens {
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username": "andrea", "password": "my-andrea-pwd"}`))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+	var tk tokens
+	json.Unmarshal(response.Body.Bytes(), &tk)
+	return tk
+}
+
+// refresh calls /token/refresh returning the response code and the new tokens
+func refresh(refreshToken string) (int, tokens) {
+	req, _ := http.NewRequest("POST", "/token/refresh",
+		strings.NewReader(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)))
+	response := executeRequest(req)
+	var tk tokens
+	json.Unmarshal(response.Body.Bytes(), &tk)
+	return response.Code, tk
+}
+
+// getProducts calls GET /products with the given access token returning the response code
+func getProducts(accessToken string) int {
+	req, _ := http.NewRequest("GET", "/products", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return executeRequest(req).Code
+}
+
+func TestLoginTokens(t *testing.T) {
+	tk := login(t)
+	if len(tk.Token) == 0 || len(tk.RefreshToken) == 0 || tk.TokenType != "Bearer" || tk.ExpiresIn <= 0 {
+		t.Errorf("unexpected login response: %+v", tk)
+	}
+	checkResponseCode(t, http.StatusOK, getProducts(tk.Token))
+	// the refresh token can't be used as an access token
+	checkResponseCode(t, http.StatusUnauthorized, getProducts(tk.RefreshToken))
+	// and the access token can't be used to refresh
+	code, _ := refresh(tk.Token)
+	checkResponseCode(t, http.StatusUnauthorized, code)
+}
+
+func TestRefreshToken(t *testing.T) {
+	first := login(t)
+
+	code, second := refresh(first.RefreshToken)
+	checkResponseCode(t, http.StatusCreated, code)
+	if second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+		t.Error("the refresh must return new tokens")
+	}
+	checkResponseCode(t, http.StatusOK, getProducts(second.Token))
+
+	// the first refresh token comes back: it is a reuse, the whole family is revoked
+	code, _ = refresh(first.RefreshToken)
+	checkResponseCode(t, http.StatusUnauthorized, code)
+	code, _ = refresh(second.RefreshToken)
+	checkResponseCode(t, http.StatusUnauthorized, code)
+
+	// a new login starts a new family
+	code, _ = refresh(login(t).RefreshToken)
+	checkResponseCode(t, http.StatusCreated, code)
+
+	code, _ = refresh("wrong")
+	checkResponseCode(t, http.StatusUnauthorized, code)
+	req, _ := http.NewRequest("POST", "/token/refresh", strings.NewReader(`{}`))
+	checkResponseCode(t, http.StatusBadRequest, executeRequest(req).Code)
+}
+
+func TestLogout(t *testing.T) {
+	tk := login(t)
+
+	req, _ := http.NewRequest("POST", "/logout", strings.NewReader(fmt.Sprintf(`{"refresh_token": "%s"}`,
+		tk.RefreshToken)))
+	req.Header.Set("Authorization", "Bearer "+tk.Token)
+	checkResponseCode(t, http.StatusNoContent, executeRequest(req).Code)
+
+	// both the tokens are revoked
+	checkResponseCode(t, http.StatusUnauthorized, getProducts(tk.Token))
+	code, _ := refresh(tk.RefreshToken)
+	checkResponseCode(t, http.StatusUnauthorized, code)
+
+	// the other sessions are still valid
+	checkResponseCode(t, http.StatusOK, getProducts(token))
+
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	checkResponseCode(t, http.StatusUnauthorized, executeRequest(req).Code)
+}
+
+func TestJWKS(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) == 0 || jwks.Keys[0]["kid"] != a.Keys.Active().ID || len(jwks.Keys[0]["kty"]) == 0 {
+		t.Errorf("unexpected jwks: %s", response.Body.String())
+	}
+	// the token header carries the kid of the active key
+	tk := login(t)
+	parts := strings.Split(tk.Token, ".")
+	header, _ := jwt.DecodeSegment(parts[0])
+	if !strings.Contains(string(header), `"kid":"`+a.Keys.Active().ID+`"`) {
+		t.Errorf("expected the kid header, got %s", header)
+	}
+}
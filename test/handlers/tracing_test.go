@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"testing"
+)
+
+// spanNamed returns the recorded span with the name
+func spanNamed(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("span %s not recorded, got %d spans", name, len(spans))
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	clearTable()
+	recorder := tracetest.NewSpanRecorder()
+	a.TracerProvider.RegisterSpanProcessor(recorder)
+	defer a.TracerProvider.UnregisterSpanProcessor(recorder)
+
+	req, _ := http.NewRequest("POST", "/products",
+		bytes.NewBufferString(`{"name": "chair", "price": 10, "sku": "abc-def-ghi"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+
+	spans := recorder.Ended()
+	server := spanNamed(t, spans, "POST /products")
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("the server span must continue the trace of the caller, got %v", server.SpanContext())
+	}
+	for _, name := range []string{"AuthMiddleware", "MiddlewareProductValidation"} {
+		if span := spanNamed(t, spans, name); span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s must be a child of the server span, got the parent %v", name, span.Parent())
+		}
+	}
+
+	// the routes not found are traced too
+	req, _ = http.NewRequest("GET", "/not-a-route", nil)
+	executeRequest(req)
+	if span := spanNamed(t, recorder.Ended(), "GET"); span.Status().Code != codes.Unset {
+		t.Errorf("a 404 is not an error of the server, got %v", span.Status())
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/mas2020-golang/rest-api/models"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedUsers adds the users of the tests, root is an admin and andrea an editor; the migrations don't create users
+func seedUsers() {
+	for _, u := range []*models.User{
+		{Username: "root", Description: "root user", Email: "root@mas2020.me", UserType: "admin",
+			ApiKey: "0a2f5aa01a6a61a34607cc9d60a2e996d5d4862ebc3aef53679f559206080e44"},
+		{Username: "andrea", Description: "simple user", Email: "andrea@mas2020.me", UserType: "editor",
+			ApiKey: "22599582a225ad6024c572e960371407c9765487e761e9e2c87e1cbbfbde1f27"},
+	} {
+		if err := a.Users.Add(context.Background(), u); err != nil && !errors.Is(err, models.ErrUserExists) {
+			log.Fatal(err)
+		}
+	}
+}
+
+// clearUsers removes the users created by the tests, only the seed users are kept
+func clearUsers() {
+	users, _ := a.Users.GetAll(context.Background())
+	for _, u := range users {
+		if u.Username != "root" && u.Username != "andrea" {
+			a.Users.Delete(context.Background(), u.ID)
+		}
+	}
+}
+
+// signToken returns a token for the given role signed with the server key
+func signToken(role string) string {
+	signed, err := a.Keys.Sign(jwt.MapClaims{
+		"name": "test",
+		"role": role,
+		"typ":  "access",
+		"jti":  uuid.NewString(),
+		"exp":  time.Now().Add(5 * time.Minute).Unix(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return signed
+}
+
+// userRequest executes a request on the users resources using the admin token
+func userRequest(method, url, body string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	return response.Code, m
+}
+
+func TestUsersCRUD(t *testing.T) {
+	clearUsers()
+	defer clearUsers()
+
+	code, m := userRequest("POST", "/users", `{"username": "mario", "description": "test user",
+"email": "mario@mas2020.me", "user-type": "reader", "password": "mario-pwd"}`)
+	checkResponseCode(t, http.StatusCreated, code)
+	if m["username"] != "mario" || m["user-type"] != "reader" || m["disabled"] != false {
+		t.Errorf("unexpected user created: %v", m)
+	}
+	id := int(m["user-id"].(float64))
+
+	// the same username can't be used twice
+	code, _ = userRequest("POST", "/users", `{"username": "mario", "password": "other"}`)
+	checkResponseCode(t, http.StatusConflict, code)
+
+	code, m = userRequest("GET", fmt.Sprintf("/users/%d", id), "")
+	checkResponseCode(t, http.StatusOK, code)
+	if m["email"] != "mario@mas2020.me" {
+		t.Errorf("expected the email mario@mas2020.me, got %v", m["email"])
+	}
+
+	code, m = userRequest("PUT", fmt.Sprintf("/users/%d", id), `{"username": "mario", "description": "changed",
+"email": "mario@mas2020.me", "user-type": "admin"}`)
+	checkResponseCode(t, http.StatusOK, code)
+	if m["description"] != "changed" || m["user-type"] != "admin" || m["updated"] == nil {
+		t.Errorf("unexpected user updated: %v", m)
+	}
+
+	// the password has not been changed by the update
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username": "mario", "password": "mario-pwd"}`))
+	checkResponseCode(t, http.StatusCreated, executeRequest(req).Code)
+
+	code, _ = userRequest("GET", "/users", "")
+	checkResponseCode(t, http.StatusOK, code)
+
+	code, _ = userRequest("DELETE", fmt.Sprintf("/users/%d", id), "")
+	checkResponseCode(t, http.StatusNoContent, code)
+	code, _ = userRequest("GET", fmt.Sprintf("/users/%d", id), "")
+	checkResponseCode(t, http.StatusNotFound, code)
+	code, _ = userRequest("DELETE", fmt.Sprintf("/users/%d", id), "")
+	checkResponseCode(t, http.StatusNotFound, code)
+}
+
+func TestUsersWrongRequests(t *testing.T) {
+	clearUsers()
+	defer clearUsers()
+
+	tests := []struct {
+		method, url, body string
+		code              int
+	}{
+		{"POST", "/users", `{"username": "nopwd"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"password": "pwd"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"username": "x", "password": "pwd", "email": "wrong"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"username": "x", "password": "pwd", "user-type": "super"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"username": "x", "password": "pwd", "api-key": "abc"}`, http.StatusBadRequest},
+		{"PUT", "/users/9999", `{"username": "x"}`, http.StatusNotFound},
+		{"POST", "/users/9999/disable", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		code, _ := userRequest(test.method, test.url, test.body)
+		if code != test.code {
+			t.Errorf("%s %s %s: expected %d, got %d", test.method, test.url, test.body, test.code, code)
+		}
+	}
+}
+
+func TestUsersApiKeyNotSerialized(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	if strings.Contains(body, "api") || strings.Contains(body, "22599582a225ad60") {
+		t.Errorf("the api key must never be returned: %s", body)
+	}
+	if !strings.Contains(body, `"username":"andrea"`) {
+		t.Errorf("expected the andrea user in %s", body)
+	}
+}
+
+func TestUsersAdminOnly(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken("user"))
+	checkResponseCode(t, http.StatusForbidden, executeRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/users", nil)
+	checkResponseCode(t, http.StatusUnauthorized, executeRequest(req).Code)
+}
+
+func TestDisableUser(t *testing.T) {
+	clearUsers()
+	defer clearUsers()
+
+	_, m := userRequest("POST", "/users", `{"username": "luigi", "password": "luigi-pwd"}`)
+	id := int(m["user-id"].(float64))
+	login := func() int {
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username": "luigi", "password": "luigi-pwd"}`))
+		return executeRequest(req).Code
+	}
+
+	code, m := userRequest("POST", fmt.Sprintf("/users/%d/disable", id), "")
+	checkResponseCode(t, http.StatusOK, code)
+	if m["disabled"] != true {
+		t.Errorf("expected the user disabled, got %v", m["disabled"])
+	}
+	checkResponseCode(t, http.StatusUnauthorized, login())
+
+	code, _ = userRequest("POST", fmt.Sprintf("/users/%d/enable", id), "")
+	checkResponseCode(t, http.StatusOK, code)
+	checkResponseCode(t, http.StatusCreated, login())
+}
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	clearUsers()
+	defer clearUsers()
+
+	// a user stored with the old unsalted sha256
+	legacy := &models.User{Username: "legacy", UserType: "reader",
+		ApiKey: fmt.Sprintf("%x", sha256.Sum256([]byte("legacy-pwd")))}
+	if err := a.Users.Add(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) int {
+		req, _ := http.NewRequest("POST", "/login",
+			strings.NewReader(fmt.Sprintf(`{"username": "legacy", "password": "%s"}`, password)))
+		return executeRequest(req).Code
+	}
+	checkResponseCode(t, http.StatusCreated, login("legacy-pwd"))
+	user, _ := a.Users.GetByUsername(context.Background(), "legacy")
+	if !strings.HasPrefix(user.ApiKey, "$argon2id$") {
+		t.Errorf("expected the password rehashed with argon2id, got %s", user.ApiKey)
+	}
+
+	// the new hash still works, a wrong password doesn't
+	checkResponseCode(t, http.StatusCreated, login("legacy-pwd"))
+	checkResponseCode(t, http.StatusUnauthorized, login("wrong"))
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mas2020-golang/rest-api/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// probe calls the handler and decodes the report
+func probe(t *testing.T, handler http.Handler) (int, health.Report) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var report health.Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("the body is not a report: %s (%s)", err, rr.Body.String())
+	}
+	return rr.Code, report
+}
+
+func TestReady(t *testing.T) {
+	checker := health.New(50 * time.Millisecond)
+	checker.Register("database", func(ctx context.Context) (string, error) {
+		return "", nil
+	})
+	checker.Register("migrations", func(ctx context.Context) (string, error) {
+		return "3 of 3 migrations applied", nil
+	})
+
+	code, report := probe(t, checker.ReadyHandler())
+	if code != http.StatusOK || report.Status != health.StatusUp || len(report.Checks) != 2 {
+		t.Fatalf("expected ready, got %d %+v", code, report)
+	}
+	if report.Checks[0].Name != "database" || report.Checks[1].Message != "3 of 3 migrations applied" {
+		t.Errorf("unexpected checks %+v", report.Checks)
+	}
+
+	// a failed check makes the application unready
+	checker.Register("cache", func(ctx context.Context) (string, error) {
+		return "", errors.New("connection refused")
+	})
+	code, report = probe(t, checker.ReadyHandler())
+	if code != http.StatusServiceUnavailable || report.Status != health.StatusDown ||
+		report.Checks[0].Status != health.StatusDown || report.Checks[0].Error != "connection refused" {
+		t.Errorf("expected unready, got %d %+v", code, report)
+	}
+}
+
+func TestTimeoutAndPanic(t *testing.T) {
+	checker := health.New(20 * time.Millisecond)
+	checker.Register("slow", func(ctx context.Context) (string, error) {
+		time.Sleep(time.Second)
+		return "", nil
+	})
+	checker.Register("broken", func(ctx context.Context) (string, error) {
+		panic("boom")
+	})
+
+	start := time.Now()
+	report := checker.Ready(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the probe must not wait for the slow check, it took %s", elapsed)
+	}
+	for _, result := range report.Checks {
+		if result.Status != health.StatusDown || len(result.Error) == 0 {
+			t.Errorf("expected a failed check, got %+v", result)
+		}
+	}
+	if slow := report.Checks[1]; slow.LatencyMs < 20 {
+		t.Errorf("expected the latency of the timeout, got %+v", slow)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	checker := health.New(0)
+	checker.Shutdown()
+	code, report := probe(t, checker.ReadyHandler())
+	if code != http.StatusServiceUnavailable || report.Status != health.StatusShuttingDown {
+		t.Errorf("expected unready during the shutdown, got %d %+v", code, report)
+	}
+
+	// the process is still alive
+	rr := httptest.NewRecorder()
+	health.LiveHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+}
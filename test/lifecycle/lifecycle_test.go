@@ -0,0 +1,49 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/mas2020-golang/rest-api/lifecycle"
+	"strings"
+	"testing"
+)
+
+func TestClose(t *testing.T) {
+	m := lifecycle.New()
+	var order []string
+	for _, name := range []string{"tracing", "database", "cache"} {
+		name := name
+		m.Register(name, func(context.Context) error {
+			order = append(order, name)
+			if name == "database" || name == "tracing" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}
+
+	err := m.Close(context.Background())
+	if strings.Join(order, ",") != "cache,database,tracing" {
+		t.Errorf("expected the reverse order of registration, got %v", order)
+	}
+	// every failure is reported, the closers after a failure are called
+	if err == nil || err.Error() != "database: failed; tracing: failed" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the closers are called once
+	if err = m.Close(context.Background()); err != nil || len(order) != 3 {
+		t.Errorf("expected nothing done by the second call, got %v %v", err, order)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs lifecycle.Errors
+	if errs.Err() != nil {
+		t.Errorf("expected nil without errors")
+	}
+	cause := errors.New("only one")
+	if err := append(errs, cause).Err(); err != cause {
+		t.Errorf("expected the only error, got %v", err)
+	}
+}
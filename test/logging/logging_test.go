@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/logging"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"github.com/mas2020-golang/rest-api/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newRouter returns a router with the access log, the handler logs with the logger of the request and sets the
+// user of the call
+func newRouter() *mux.Router {
+	// the server spans continue the trace of the traceparent header
+	tracing.Setup(tracing.Config{}, io.Discard)
+	router := mux.NewRouter()
+	router.Use(utils.RequestIDMiddleware, tracing.Middleware("test"), logging.AccessLog)
+	router.HandleFunc("/products/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.SetUser(r.Context(), "jdoe")
+		logging.FromContext(ctx).Info("from the handler")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+	})
+	return router
+}
+
+// records decodes the JSON records written to buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var list []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("the record is not JSON: %s (%s)", err, line)
+		}
+		list = append(list, record)
+	}
+	return list
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := logging.Setup(int(logrus.InfoLevel), logging.FormatJSON, buf); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, "/products/42", nil)
+	req.RemoteAddr = "10.1.2.3:41234"
+	req.Header.Set(utils.RequestIDHeader, "req-1")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, req)
+
+	if rr.Header().Get(utils.RequestIDHeader) != "req-1" {
+		t.Errorf("expected the request id echoed, got %s", rr.Header().Get(utils.RequestIDHeader))
+	}
+	list := records(t, buf)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(list))
+	}
+	// the records of the handler have the fields of the request
+	if list[0]["msg"] != "from the handler" || list[0]["request_id"] != "req-1" || list[0]["user"] != "jdoe" {
+		t.Errorf("unexpected record %v", list[0])
+	}
+	access := list[1]
+	expected := map[string]interface{}{
+		"method":     "PUT",
+		"route":      "/products/{id:[0-9]+}",
+		"path":       "/products/42",
+		"status":     float64(http.StatusAccepted),
+		"bytes":      float64(5),
+		"remote_ip":  "10.1.2.3",
+		"user":       "jdoe",
+		"request_id": "req-1",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+	for field, value := range expected {
+		if access[field] != value {
+			t.Errorf("%s: expected %v, got %v", field, value, access[field])
+		}
+	}
+	if _, ok := access["duration_ms"].(float64); !ok {
+		t.Errorf("expected the duration, got %v", access["duration_ms"])
+	}
+}
+
+func TestFormats(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := logging.Setup(int(logrus.InfoLevel), logging.FormatLogfmt, buf); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/products/1", nil)
+	newRouter().ServeHTTP(httptest.NewRecorder(), req)
+	if out := buf.String(); !strings.Contains(out, "status=202") || !strings.Contains(out, "user=jdoe") {
+		t.Errorf("expected logfmt records, got %s", out)
+	}
+
+	if err := logging.Setup(int(logrus.InfoLevel), "xml", buf); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	// without a logger in the context the standard one is used
+	if logging.FromContext(context.Background()).Logger != logrus.StandardLogger() {
+		t.Errorf("expected the standard logger")
+	}
+}
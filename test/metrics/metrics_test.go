@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/mas2020-golang/rest-api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// contains checks that the exposition has all the lines
+func contains(t *testing.T, exposition string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(exposition, line+"\n") {
+			t.Errorf("expected the line %q in:\n%s", line, exposition)
+		}
+	}
+}
+
+// scrape returns the metrics of gatherer in the text format
+func scrape(t *testing.T, gatherer prometheus.Gatherer) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	metrics.Handler(gatherer, "").ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("expected 200 with the Prometheus text format, got %d %v", rr.Code, rr.Header())
+	}
+	return rr.Body.String()
+}
+
+func TestHandlerToken(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "answer", Help: "The answer."},
+		func() float64 { return 42 }))
+	handler := metrics.Handler(registry, "secret")
+
+	// without the token
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized || len(rr.Header().Get("WWW-Authenticate")) == 0 {
+		t.Errorf("expected 401 with WWW-Authenticate, got %d %v", rr.Code, rr.Header())
+	}
+
+	// with the token
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	contains(t, rr.Body.String(), "# TYPE answer gauge", "answer 42")
+}
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(metrics.Middleware)
+	router.HandleFunc("/items/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	router.NotFoundHandler = metrics.Middleware(http.NotFoundHandler())
+
+	for _, path := range []string{"/items/1", "/items/2", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	// the methods not defined by HTTP don't add series
+	for _, method := range []string{"BREW", "PROPFIND"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items/3", nil))
+	}
+
+	exposition := scrape(t, metrics.Default)
+	contains(t, exposition,
+		`http_requests_total{method="GET",route="/items/{id:[0-9]+}",status="418"} 2`,
+		`http_requests_total{method="GET",route="none",status="404"} 1`,
+		`http_requests_total{method="OTHER",route="/items/{id:[0-9]+}",status="418"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/items/{id:[0-9]+}",status="418"} 2`,
+		"# TYPE go_goroutines gauge",
+		"# TYPE process_start_time_seconds gauge")
+	if strings.Contains(exposition, `method="BREW"`) {
+		t.Errorf("the method BREW must be counted as OTHER")
+	}
+}
@@ -0,0 +1,228 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mas2020-golang/rest-api/migrations"
+	"github.com/mas2020-golang/rest-api/models"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	list, err := migrations.Load()
+	if err != nil {
+		t.Fatalf("Load returned an error: %s", err.Error())
+	}
+	if len(list) == 0 {
+		t.Fatal("no migrations loaded")
+	}
+	for i, m := range list {
+		if i > 0 && m.Version <= list[i-1].Version {
+			t.Errorf("migrations are not ordered: %d comes after %d", m.Version, list[i-1].Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("migration %d_%s must have both the up and the down file", m.Version, m.Name)
+		}
+		if len(m.Checksum) != 64 {
+			t.Errorf("migration %d_%s has a wrong checksum '%s'", m.Version, m.Name, m.Checksum)
+		}
+	}
+	if list[0].Name != "create_products" {
+		t.Errorf("expected the first migration to be create_products, got %s", list[0].Name)
+	}
+}
+
+// newDatabase creates a database on the server of the APP_DB_* variables and returns a pool connected to it, the
+// database is dropped at the end of the test
+func newDatabase(t *testing.T) *pgxpool.Pool {
+	if len(os.Getenv("APP_DB_HOST")) == 0 {
+		t.Skip("APP_DB_HOST is not set, the test needs a PostgreSQL database")
+	}
+	ctx := context.Background()
+	url := func(name string) string {
+		return fmt.Sprintf("postgres://%s:%s@%s:5432/%s", os.Getenv("APP_DB_USERNAME"), os.Getenv("APP_DB_PASSWORD"),
+			os.Getenv("APP_DB_HOST"), name)
+	}
+	admin, err := pgxpool.Connect(ctx, url(os.Getenv("APP_DB_NAME")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("test_migrations_%d", time.Now().UnixNano())
+	if _, err = admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.Connect(ctx, url(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		admin.Exec(context.Background(), "DROP DATABASE "+name)
+		admin.Close()
+	})
+	return pool
+}
+
+// TestUpDown runs the migrations against an empty database, it needs the APP_DB_* variables
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	pool := newDatabase(t)
+	m, err := migrations.New(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("Up returned an error: %s", err.Error())
+	}
+	// a second run has nothing to do
+	applied, err := m.Up(ctx)
+	if err != nil || applied != 0 {
+		t.Errorf("expected no migrations applied the second time, got %d (err: %v)", applied, err)
+	}
+
+	// roll back the last migration and apply it again
+	rolledBack, err := m.Down(ctx, 1)
+	if err != nil || rolledBack != 1 {
+		t.Fatalf("expected 1 migration rolled back, got %d (err: %v)", rolledBack, err)
+	}
+	if pending, _ := m.Pending(ctx); pending != 1 {
+		t.Errorf("expected 1 pending migration, got %d", pending)
+	}
+	if applied, err = m.Up(ctx); err != nil || applied != 1 {
+		t.Errorf("expected 1 migration applied, got %d (err: %v)", applied, err)
+	}
+
+	// every down migration is the inverse of its up one: the whole chain is rolled back and applied again
+	list, _ := migrations.Load()
+	if rolledBack, err = m.Down(ctx, len(list)); err != nil || rolledBack != len(list) {
+		t.Fatalf("expected %d migrations rolled back, got %d (err: %v)", len(list), rolledBack, err)
+	}
+	if applied, err = m.Up(ctx); err != nil || applied != len(list) {
+		t.Fatalf("expected %d migrations applied, got %d (err: %v)", len(list), applied, err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedOn == nil {
+			t.Errorf("migration %d_%s is not applied", s.Version, s.Name)
+		}
+	}
+}
+
+// baseline is the schema created by the init scripts of the first release (scripts/db), before the migrations
+const baseline = `
+CREATE TABLE IF NOT EXISTS products
+(
+    id SERIAL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL,
+    price NUMERIC(10,2) NOT NULL DEFAULT 0.00,
+    sku varchar(100),
+    CONSTRAINT products_pkey PRIMARY KEY (id)
+);
+
+CREATE TABLE public.users
+(
+    user_id            serial                      NOT NULL,
+    username           character varying(100)      NOT NULL,
+    description        character varying(500),
+    email              character varying(200),
+    api_key            character varying(64),
+    api_key_updated    timestamp without time zone,
+    api_key_expiration timestamp without time zone,
+    user_type          character varying(10),
+    created            timestamp without time zone NOT NULL,
+    updated            timestamp without time zone,
+    disabled           boolean                     NOT NULL,
+    PRIMARY KEY (user_id)
+);
+
+INSERT INTO products (name, description, price, sku) VALUES ('Tea', 'Green tea', 2.50, 'tea-tea-tea');
+`
+
+// TestFromBaseline migrates a database created by the old init scripts: the tables exist but not the columns
+// added later, the migrations must add them keeping the data
+func TestFromBaseline(t *testing.T) {
+	ctx := context.Background()
+	pool := newDatabase(t)
+	if _, err := pool.Exec(ctx, baseline); err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrations.New(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatalf("Up returned an error: %s", err.Error())
+	}
+
+	// the repository reads the new columns
+	repo := models.NewPgProducts(pool)
+	page, err := repo.GetAll(ctx, models.ProductQuery{Search: "tea"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Name != "Tea" || page.Items[0].Version != 1 {
+		t.Fatalf("expected the product of the baseline, got %+v", page.Items)
+	}
+	if err = repo.Delete(ctx, page.Items[0].ID); err != nil {
+		t.Errorf("the product can't be deleted: %s", err)
+	}
+	var indexes int
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM pg_indexes WHERE indexname = 'products_fts_idx'").Scan(&indexes)
+	if err != nil || indexes != 1 {
+		t.Errorf("expected the full text search index, got %d (err: %v)", indexes, err)
+	}
+	// the migrations don't create users, the first admin is created with the command line
+	var users int
+	if err = pool.QueryRow(ctx, "SELECT count(*) FROM users").Scan(&users); err != nil || users != 0 {
+		t.Errorf("expected no users, got %d (err: %v)", users, err)
+	}
+}
+
+// TestStatusReadOnly checks that the status neither creates schema_migrations nor waits for the advisory lock held
+// by a replica migrating
+func TestStatusReadOnly(t *testing.T) {
+	ctx := context.Background()
+	pool := newDatabase(t)
+	m, err := migrations.New(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the lock of the migrations on another connection
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock(6170923418)"); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(6170923418)")
+
+	timeout, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	pending, err := m.Pending(timeout)
+	if err != nil {
+		t.Fatalf("Pending returned an error: %s", err.Error())
+	}
+	if list, _ := migrations.Load(); pending != len(list) {
+		t.Errorf("expected %d pending migrations, got %d", len(list), pending)
+	}
+	var table *string
+	if err = pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations')::text").Scan(&table); err != nil {
+		t.Fatal(err)
+	}
+	if table != nil {
+		t.Errorf("the status must not create the schema_migrations table")
+	}
+}
@@ -6,7 +6,7 @@ import (
 )
 
 // TestValidation test the Product validation
-func TestValidation(t *testing.T){
+func TestValidation(t *testing.T) {
 	p := &models.Product{}
 	p.Name = "Test"
 	p.Price = 1.99
@@ -16,3 +16,32 @@ func TestValidation(t *testing.T){
 		t.Fatal(err)
 	}
 }
+
+// TestApplyPatch test that only the changed columns are returned by ApplyPatch
+func TestApplyPatch(t *testing.T) {
+	p := &models.Product{ID: 1, Name: "Test", Description: "desc", Price: 1.99, SKU: "ads-fdsd-sdas"}
+	patched, columns, err := p.ApplyPatch(models.MergePatchType, []byte(`{"price": 2.5, "name": "Test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Price != 2.5 || p.Price != 1.99 {
+		t.Errorf("Expected the patched price 2.5 and the original one untouched. Got %v and %v", patched.Price,
+			p.Price)
+	}
+	if len(columns) != 1 || columns[0] != "price" {
+		t.Errorf("Expected only price to be changed. Got %v", columns)
+	}
+
+	patched, columns, err = p.ApplyPatch(models.JSONPatchType,
+		[]byte(`[{"op": "remove", "path": "/description"}, {"op": "copy", "from": "/name", "path": "/sku"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Description != "" || patched.SKU != "Test" || len(columns) != 2 {
+		t.Errorf("Expected description and sku to be changed. Got %#v (%v)", patched, columns)
+	}
+
+	if _, _, err = p.ApplyPatch("application/json", []byte(`{}`)); err != models.ErrPatchMediaType {
+		t.Errorf("Expected ErrPatchMediaType. Got '%v'", err)
+	}
+}
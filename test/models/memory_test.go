@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/models"
+	"sync"
+	"testing"
+)
+
+// TestMemProducts test the in memory repository lifecycle: add, update, delete and restore
+func TestMemProducts(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewMemProducts()
+	p := &models.Product{Name: "test", Price: 1.99, SKU: "ads-fdsd-sdas"}
+	if err := repo.Add(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != 1 {
+		t.Errorf("Expected product ID to be '1'. Got '%v'", p.ID)
+	}
+
+	p.Name = "updated"
+	if err := repo.Update(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.Get(ctx, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "updated" {
+		t.Errorf("Expected product name to be 'updated'. Got '%v'", stored.Name)
+	}
+
+	if err := repo.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, 1, false); err != models.RecordNotFound {
+		t.Errorf("Expected RecordNotFound for a deleted product. Got '%v'", err)
+	}
+	if err := repo.Update(ctx, p); err != models.RecordNotFound {
+		t.Errorf("Expected RecordNotFound updating a deleted product. Got '%v'", err)
+	}
+	page, _ := repo.GetAll(ctx, models.ProductQuery{IncludeDeleted: true})
+	if len(page.Items) != 1 || page.Items[0].DeletedOn == nil {
+		t.Errorf("Expected 1 deleted product using IncludeDeleted. Got %v", page.Items)
+	}
+
+	if err := repo.Restore(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Restore(ctx, 1); err != models.RecordNotFound {
+		t.Errorf("Expected RecordNotFound restoring a product not deleted. Got '%v'", err)
+	}
+}
+
+// TestMemProductsConcurrency adds products from many goroutines (run it with -race)
+func TestMemProductsConcurrency(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewMemProducts()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repo.Add(ctx, &models.Product{Name: fmt.Sprintf("test-%d", i), Price: 1, SKU: "ads-fdsd-sdas"})
+			repo.GetAll(ctx, models.ProductQuery{})
+		}(i)
+	}
+	wg.Wait()
+	page, _ := repo.GetAll(ctx, models.ProductQuery{Limit: 100})
+	if len(page.Items) != 50 {
+		t.Errorf("Expected 50 products. Got %d", len(page.Items))
+	}
+	for i, p := range page.Items {
+		if p.ID != i+1 {
+			t.Errorf("Expected products ordered by id, got id %d at position %d", p.ID, i)
+		}
+	}
+}
+
+// TestMemProductsPagination reads the pages using both offset and cursor
+func TestMemProductsPagination(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewMemProducts()
+	for i := 0; i < 5; i++ {
+		repo.Add(ctx, &models.Product{Name: fmt.Sprintf("test-%d", i), Price: 1, SKU: "ads-fdsd-sdas"})
+	}
+
+	page, _ := repo.GetAll(ctx, models.ProductQuery{Limit: 2})
+	if len(page.Items) != 2 || page.TotalCount != 5 || len(page.NextCursor) == 0 {
+		t.Fatalf("Expected 2 products of 5 and a next cursor. Got %d of %d (%q)", len(page.Items), page.TotalCount,
+			page.NextCursor)
+	}
+	after, err := models.DecodeCursor(models.ProductQuery{}, page.NextCursor)
+	if err != nil || after.Values[0] != 2 {
+		t.Fatalf("Expected cursor after id 2. Got %v (%v)", after, err)
+	}
+	page, _ = repo.GetAll(ctx, models.ProductQuery{Limit: 2, After: after})
+	if page.Items[0].ID != 3 || page.Items[1].ID != 4 {
+		t.Errorf("Expected products 3 and 4. Got %d and %d", page.Items[0].ID, page.Items[1].ID)
+	}
+
+	page, _ = repo.GetAll(ctx, models.ProductQuery{Limit: 2, Offset: 4})
+	if len(page.Items) != 1 || page.Items[0].ID != 5 || len(page.NextCursor) != 0 {
+		t.Errorf("Expected only the product 5 and no next cursor. Got %v (%q)", page.Items, page.NextCursor)
+	}
+
+	if _, err := models.DecodeCursor(models.ProductQuery{}, "not-a-cursor"); err != models.ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor. Got '%v'", err)
+	}
+}
+
+// TestMemProductsFilters test the filters and the sort, following the cursor with a custom sort
+func TestMemProductsFilters(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewMemProducts()
+	for _, p := range []models.Product{
+		{Name: "Espresso", Description: "short and strong coffee", Price: 2.5, SKU: "cof-esp-one"},
+		{Name: "Cappuccino", Description: "coffee with milk", Price: 3, SKU: "cof-cap-one"},
+		{Name: "Green tea", Description: "hot tea", Price: 2.5, SKU: "tea-gre-one"},
+		{Name: "Espresso doppio", Description: "double coffee", Price: 4, SKU: "cof-esp-two"},
+	} {
+		p := p
+		repo.Add(ctx, &p)
+	}
+
+	min, max := float32(2.5), float32(3)
+	tests := []struct {
+		name string
+		q    models.ProductQuery
+		ids  string
+	}{
+		{"price range", models.ProductQuery{PriceMin: &min, PriceMax: &max}, "[1 2 3]"},
+		{"exact sku", models.ProductQuery{SKU: "cof-esp-one"}, "[1]"},
+		{"sku prefix", models.ProductQuery{SKUPrefix: "cof-esp"}, "[1 4]"},
+		{"name contains", models.ProductQuery{Name: "espresso"}, "[1 4]"},
+		{"search", models.ProductQuery{Search: "strong coffee"}, "[1]"},
+		{"sort", models.ProductQuery{Sort: []models.SortField{{Column: "price", Desc: true}, {Column: "name"}}},
+			"[4 2 1 3]"},
+	}
+	for _, test := range tests {
+		page, _ := repo.GetAll(ctx, test.q)
+		var ids []int
+		for _, p := range page.Items {
+			ids = append(ids, p.ID)
+		}
+		if fmt.Sprint(ids) != test.ids {
+			t.Errorf("%s: expected products %s. Got %v", test.name, test.ids, ids)
+		}
+	}
+
+	// follow the cursor using a custom sort
+	q := models.ProductQuery{Limit: 1}
+	q.Sort, _ = models.ParseSort("-price,name")
+	var ids []int
+	for i := 0; i < 4; i++ {
+		page, _ := repo.GetAll(ctx, q)
+		ids = append(ids, page.Items[0].ID)
+		if len(page.NextCursor) == 0 {
+			break
+		}
+		var err error
+		if q.After, err = models.DecodeCursor(q, page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(ids) != "[4 2 1 3]" {
+		t.Errorf("Expected products [4 2 1 3] following the cursor. Got %v", ids)
+	}
+	// the cursor is valid only for the same sort
+	page, _ := repo.GetAll(ctx, models.ProductQuery{Limit: 1})
+	if _, err := models.DecodeCursor(q, page.NextCursor); err != models.ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor using a cursor of another sort. Got '%v'", err)
+	}
+}
+
+// TestParseSort test the sort whitelist
+func TestParseSort(t *testing.T) {
+	fields, err := models.ParseSort("-price,name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || fields[0] != (models.SortField{Column: "price", Desc: true}) ||
+		fields[1] != (models.SortField{Column: "name"}) {
+		t.Errorf("Expected -price,name. Got %v", fields)
+	}
+	for _, s := range []string{"description", "price;drop table products", "name,-name", ""} {
+		if _, err := models.ParseSort(s); err == nil {
+			t.Errorf("Expected an error for sort '%s'", s)
+		}
+	}
+}
+
+// TestMemProductsVersion test the version check of Update and Patch
+func TestMemProductsVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewMemProducts()
+	p := &models.Product{Name: "test", Price: 1.99, SKU: "ads-fdsd-sdas"}
+	repo.Add(ctx, p)
+	if p.Version != 1 {
+		t.Fatalf("Expected version 1. Got %d", p.Version)
+	}
+	if err := repo.Update(ctx, p); err != nil || p.Version != 2 {
+		t.Fatalf("Expected version 2. Got %d (%v)", p.Version, err)
+	}
+	stale := *p
+	stale.Version = 1
+	if err := repo.Update(ctx, &stale); err != models.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch. Got '%v'", err)
+	}
+	if err := repo.Patch(ctx, &stale, []string{"name"}); err != models.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch. Got '%v'", err)
+	}
+	stale.Version = 0
+	if err := repo.Patch(ctx, &stale, []string{"name"}); err != nil || stale.Version != 3 {
+		t.Errorf("Expected version 3 patching without a version. Got %d (%v)", stale.Version, err)
+	}
+}
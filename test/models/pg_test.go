@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/mas2020-golang/rest-api/migrations"
+	"github.com/mas2020-golang/rest-api/models"
+	"os"
+	"testing"
+	"time"
+)
+
+// pgPool returns a pool connected to a new database on the server of the APP_DB_* variables, with the migrations
+// applied and no products. The database is dropped at the end of the test, so the test doesn't touch the tables of
+// the other packages.
+func pgPool(t *testing.T) *pgxpool.Pool {
+	if len(os.Getenv("APP_DB_HOST")) == 0 {
+		t.Skip("APP_DB_HOST is not set, the test needs a PostgreSQL database")
+	}
+	ctx := context.Background()
+	url := func(name string) string {
+		return fmt.Sprintf("postgres://%s:%s@%s:5432/%s", os.Getenv("APP_DB_USERNAME"), os.Getenv("APP_DB_PASSWORD"),
+			os.Getenv("APP_DB_HOST"), name)
+	}
+	admin, err := pgxpool.Connect(ctx, url(os.Getenv("APP_DB_NAME")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("test_models_%d", time.Now().UnixNano())
+	if _, err = admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.Connect(ctx, url(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		admin.Exec(context.Background(), "DROP DATABASE "+name)
+		admin.Close()
+	})
+	m, err := migrations.New(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// TestPgPriceBoundaries checks that the price filters and the keyset cursor on the price include the products
+// having exactly the bound price, that a float32 can't represent exactly
+func TestPgPriceBoundaries(t *testing.T) {
+	ctx := context.Background()
+	repo := models.NewPgProducts(pgPool(t))
+	for i, price := range []float32{19.98, 19.99, 19.99, 20.01} {
+		p := &models.Product{Name: "product", Price: price, SKU: fmt.Sprintf("abc-abc-ab%c", 'a'+i)}
+		if err := repo.Add(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	min, max := float32(19.99), float32(19.99)
+	tests := []struct {
+		name     string
+		q        models.ProductQuery
+		expected int
+	}{
+		{"price_max", models.ProductQuery{PriceMax: &max}, 3},
+		{"price_min", models.ProductQuery{PriceMin: &min}, 3},
+		{"price_min and price_max", models.ProductQuery{PriceMin: &min, PriceMax: &max}, 2},
+	}
+	for _, test := range tests {
+		page, err := repo.GetAll(ctx, test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != test.expected || page.TotalCount != test.expected {
+			t.Errorf("%s: expected %d products, got %d (total %d)", test.name, test.expected, len(page.Items),
+				page.TotalCount)
+		}
+	}
+
+	// one product per page sorted by price: every product is read once
+	sort, _ := models.ParseSort("price")
+	q := models.ProductQuery{Sort: sort, Limit: 1}
+	seen := map[int]bool{}
+	for page := 0; page < 10; page++ {
+		result, err := repo.GetAll(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range result.Items {
+			if seen[p.ID] {
+				t.Errorf("product %d read twice", p.ID)
+			}
+			seen[p.ID] = true
+		}
+		if len(result.NextCursor) == 0 {
+			break
+		}
+		if q.After, err = models.DecodeCursor(q, result.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected the 4 products, got %d", len(seen))
+	}
+}
@@ -0,0 +1,123 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mas2020-golang/rest-api/problem"
+	"github.com/mas2020-golang/rest-api/utils"
+	"github.com/mas2020-golang/rest-api/validation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// write serves err through the request id middleware and returns the response with the decoded problem
+func write(t *testing.T, err error, header string) (*httptest.ResponseRecorder, problem.Problem) {
+	handler := utils.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, err)
+	}))
+	req, _ := http.NewRequest(http.MethodGet, "/products/1", nil)
+	if len(header) > 0 {
+		req.Header.Set(utils.RequestIDHeader, header)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var p problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("the body is not a problem: %s (%s)", err, rr.Body.String())
+	}
+	return rr, p
+}
+
+func TestWrite(t *testing.T) {
+	rr, p := write(t, problem.NotFound("product not found"), "my-request-1")
+	if rr.Code != http.StatusNotFound || p.Status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d (status %d)", rr.Code, p.Status)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("expected the Content-Type %s, got %s", problem.ContentType, ct)
+	}
+	if p.Type != problem.TypePrefix+problem.KindNotFound || p.Title != "Not Found" ||
+		p.Detail != "product not found" || p.Instance != "/products/1" {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	// the id sent by the caller is used and returned
+	if p.RequestID != "my-request-1" || rr.Header().Get(utils.RequestIDHeader) != "my-request-1" {
+		t.Errorf("expected the request id my-request-1, got %s and %s", p.RequestID,
+			rr.Header().Get(utils.RequestIDHeader))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	// a missing or wrong id is replaced with a new one
+	for _, header := range []string{"", "wrong id\twith spaces", strings.Repeat("a", 129)} {
+		rr, p := write(t, problem.Conflict("duplicate"), header)
+		if len(p.RequestID) == 0 || p.RequestID == header || rr.Header().Get(utils.RequestIDHeader) != p.RequestID {
+			t.Errorf("%q: expected a new request id, got %q", header, p.RequestID)
+		}
+	}
+}
+
+func TestInternalErrors(t *testing.T) {
+	// the cause of an internal error is never returned
+	cause := errors.New(`ERROR: duplicate key value violates unique constraint "products_pkey" (SQLSTATE 23505)`)
+	for _, err := range []error{cause, problem.Internal(cause)} {
+		rr, p := write(t, err, "")
+		if rr.Code != http.StatusInternalServerError || p.Type != problem.TypePrefix+problem.KindInternal {
+			t.Errorf("expected an internal error, got %d %s", rr.Code, p.Type)
+		}
+		if strings.Contains(rr.Body.String(), "SQLSTATE") {
+			t.Errorf("the cause of the error is in the response: %s", rr.Body.String())
+		}
+	}
+	if !errors.Is(problem.Internal(cause), cause) {
+		t.Errorf("the cause of the error must be unwrapped")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	type product struct {
+		Name  string  `validate:"required"`
+		Price float32 `validate:"gt=0"`
+	}
+	err := validation.Struct(&product{Price: -1})
+	rr, p := write(t, problem.Validation(err), "")
+	if rr.Code != http.StatusBadRequest || p.Type != problem.TypePrefix+problem.KindValidation {
+		t.Errorf("expected a validation error, got %d %s", rr.Code, p.Type)
+	}
+	if len(p.Errors) != 2 || p.Errors[0].Field != "Name" || p.Errors[0].Rule != "required" ||
+		p.Errors[1].Field != "Price" || p.Errors[1].Rule != "gt" || p.Errors[1].Message != "Price must be greater than 0" {
+		t.Errorf("unexpected field errors %+v", p.Errors)
+	}
+	if strings.Contains(rr.Body.String(), "Key: ") {
+		t.Errorf("the raw validator error is in the response: %s", rr.Body.String())
+	}
+}
+
+func TestInvalidJSON(t *testing.T) {
+	var v struct {
+		Price float32 `json:"price"`
+	}
+	tests := []struct {
+		body   string
+		field  string
+		detail string
+	}{
+		{``, "", "the request body is empty"},
+		{`{"price": "a"}`, "price", "the request body has fields of the wrong type"},
+		{`{"price": `, "", "the request body is truncated"},
+		{`{"price" 1}`, "", "the request body is not a valid JSON document (offset 10)"},
+	}
+	for _, test := range tests {
+		err := json.NewDecoder(strings.NewReader(test.body)).Decode(&v)
+		_, p := write(t, problem.InvalidJSON(err), "")
+		if p.Status != http.StatusBadRequest || p.Detail != test.detail {
+			t.Errorf("%s: unexpected problem %+v", test.body, p)
+		}
+		if len(test.field) > 0 && (len(p.Errors) != 1 || p.Errors[0].Field != test.field) {
+			t.Errorf("%s: expected an error on %s, got %+v", test.body, test.field, p.Errors)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package security
+
+import (
+	"github.com/mas2020-golang/rest-api/security"
+	"testing"
+)
+
+func TestApiKey(t *testing.T) {
+	key, prefix, hash, err := security.NewApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := security.ApiKeyPrefix(key); !ok || p != prefix {
+		t.Errorf("expected the prefix %s, got %s", prefix, p)
+	}
+	if !security.VerifyApiKey(key, hash) || security.VerifyApiKey(key+"x", hash) {
+		t.Errorf("the key must match only its hash")
+	}
+	other, _, _, _ := security.NewApiKey()
+	if other == key {
+		t.Errorf("the keys must be random")
+	}
+	for _, k := range []string{"", "rak_", "rak__secret", "rak_prefix", "rak_prefix_", "xyz_prefix_secret"} {
+		if _, ok := security.ApiKeyPrefix(k); ok {
+			t.Errorf("%s must not be a valid api key", k)
+		}
+	}
+}
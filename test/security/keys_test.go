@@ -0,0 +1,175 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/security"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey generates a key for the algorithm and writes the private and the public PEM files into dir
+func writeKey(t *testing.T, dir, id, algorithm string) (private, public string) {
+	var (
+		key interface{}
+		err error
+	)
+	switch algorithm {
+	case security.RS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case security.ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case security.EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(key.(crypto.Signer).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, public = filepath.Join(dir, id+".pem"), filepath.Join(dir, id+".pub.pem")
+	ioutil.WriteFile(private, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	ioutil.WriteFile(public, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}), 0644)
+	return private, public
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"name": "test", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	for _, algorithm := range []string{security.RS256, security.ES256, security.EdDSA} {
+		private, _ := writeKey(t, dir, algorithm, algorithm)
+		keys, err := security.LoadKeySet([]security.KeyConfig{
+			{ID: algorithm, Algorithm: algorithm, PrivateKey: private},
+		}, "")
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		signed, err := keys.Sign(claims())
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		token, err := keys.Parse(signed, jwt.MapClaims{})
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		if token.Header["kid"] != algorithm || token.Header["alg"] != algorithm {
+			t.Errorf("%s: wrong header %v", algorithm, token.Header)
+		}
+		jwks := keys.JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != algorithm || jwks.Keys[0].Alg != algorithm {
+			t.Errorf("%s: wrong jwks %+v", algorithm, jwks)
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldPrivate, oldPublic := writeKey(t, dir, "old", security.RS256)
+	newPrivate, _ := writeKey(t, dir, "new", security.EdDSA)
+	old, err := security.LoadKeySet([]security.KeyConfig{
+		{ID: "old", Algorithm: security.RS256, PrivateKey: oldPrivate},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedOld, _ := old.Sign(claims())
+
+	// the old key is retired: it only verifies the tokens it signed
+	keys, err := security.LoadKeySet([]security.KeyConfig{
+		{ID: "old", Algorithm: security.RS256, PublicKey: oldPublic},
+		{ID: "new", Algorithm: security.EdDSA, PrivateKey: newPrivate},
+	}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Active().ID != "new" {
+		t.Errorf("expected the active key new, got %s", keys.Active().ID)
+	}
+	if _, err = keys.Parse(signedOld, jwt.MapClaims{}); err != nil {
+		t.Errorf("the token of the retired key must be valid: %s", err)
+	}
+	if len(keys.JWKS().Keys) != 2 {
+		t.Errorf("expected 2 keys in the jwks, got %d", len(keys.JWKS().Keys))
+	}
+	// a key set without the kid of the token rejects it
+	signedNew, _ := keys.Sign(claims())
+	if _, err = old.Parse(signedNew, jwt.MapClaims{}); err == nil {
+		t.Errorf("the token of an unknown key must be rejected")
+	}
+
+	// a retired key can't be the active one
+	_, err = security.LoadKeySet([]security.KeyConfig{
+		{ID: "old", Algorithm: security.RS256, PublicKey: oldPublic},
+	}, "old")
+	if err == nil {
+		t.Errorf("a key without the private key can't sign")
+	}
+}
+
+func TestKeySetWrongConfig(t *testing.T) {
+	dir := t.TempDir()
+	private, _ := writeKey(t, dir, "ec", security.ES256)
+	configs := [][]security.KeyConfig{
+		// algorithm not matching the key
+		{{ID: "ec", Algorithm: security.RS256, PrivateKey: private}},
+		// missing id
+		{{Algorithm: security.ES256, PrivateKey: private}},
+		// repeated id
+		{
+			{ID: "ec", Algorithm: security.ES256, PrivateKey: private},
+			{ID: "ec", Algorithm: security.ES256, PrivateKey: private},
+		},
+		// missing file
+		{{ID: "ec", Algorithm: security.ES256, PrivateKey: filepath.Join(dir, "missing.pem")}},
+	}
+	for i, c := range configs {
+		if _, err := security.LoadKeySet(c, ""); err == nil {
+			t.Errorf("config %d: expected an error", i)
+		}
+	}
+}
+
+// TestKeySetPinnedAlgorithm checks that a token can't choose the algorithm used to verify it
+func TestKeySetPinnedAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	private, public := writeKey(t, dir, "rsa", security.RS256)
+	keys, err := security.LoadKeySet([]security.KeyConfig{
+		{ID: "rsa", Algorithm: security.RS256, PrivateKey: private},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HS256 signed with the public key as secret
+	secret, _ := ioutil.ReadFile(public)
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	hs.Header["kid"] = "rsa"
+	signed, _ := hs.SignedString(secret)
+	if _, err = keys.Parse(signed, jwt.MapClaims{}); err == nil {
+		t.Errorf("a HS256 token must be rejected")
+	}
+	// alg none
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims())
+	none.Header["kid"] = "rsa"
+	signed, _ = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err = keys.Parse(signed, jwt.MapClaims{}); err == nil {
+		t.Errorf("an unsigned token must be rejected")
+	}
+}
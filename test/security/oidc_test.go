@@ -0,0 +1,283 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mas2020-golang/rest-api/security"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// idp is a stand-in for an OpenID Connect identity provider publishing its discovery document and its keys
+type idp struct {
+	*httptest.Server
+	mu        sync.Mutex
+	keys      map[string]crypto.Signer
+	jwksCalls int
+}
+
+func newIdP(t *testing.T) *idp {
+	p := &idp{keys: map[string]crypto.Signer{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.jwksCalls++
+		set := security.JWKS{}
+		for kid, key := range p.keys {
+			set.Keys = append(set.Keys, toJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// calls returns how many times the keys have been fetched
+func (p *idp) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksCalls
+}
+
+// addKey creates a new key of the provider, RS256 or ES256
+func (p *idp) addKey(t *testing.T, kid, algorithm string) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	if algorithm == security.ES256 {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[kid] = key
+}
+
+// sign returns a token signed with the key kid, the algorithm is the one of the key
+func (p *idp) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	p.mu.Lock()
+	key := p.keys[kid]
+	p.mu.Unlock()
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// claims returns valid claims for the provider
+func (p *idp) claims(groups ...interface{}) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                p.URL,
+		"aud":                "rest-api",
+		"sub":                "1234",
+		"preferred_username": "jdoe",
+		"groups":             groups,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nbf":                time.Now().Unix(),
+	}
+}
+
+func toJWK(kid string, public crypto.PublicKey) security.JWK {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return security.JWK{Kty: "RSA", Kid: kid, Use: "sig", N: enc(pub.N.Bytes()),
+			E: enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return security.JWK{Kty: "EC", Kid: kid, Alg: security.ES256, Use: "sig", Crv: "P-256",
+			X: enc(pub.X.FillBytes(make([]byte, 32))), Y: enc(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	return security.JWK{}
+}
+
+func newVerifier(t *testing.T, p *idp, defaultRole string) *security.OIDCVerifier {
+	v, err := security.NewOIDCVerifier(security.OIDCConfig{
+		Issuer:         p.URL,
+		Audience:       "rest-api",
+		RoleClaim:      "groups",
+		Roles:          map[string]string{"api-admins": security.RoleAdmin, "api-editors": security.RoleEditor},
+		DefaultRole:    defaultRole,
+		JWKSMinRefresh: time.Hour,
+	}, p.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestOIDCVerify(t *testing.T) {
+	p := newIdP(t)
+	p.addKey(t, "rsa", security.RS256)
+	p.addKey(t, "ec", security.ES256)
+	v := newVerifier(t, p, security.RoleReader)
+
+	roles := []struct {
+		groups []interface{}
+		role   string
+	}{
+		{[]interface{}{"api-editors", "others"}, security.RoleEditor},
+		{[]interface{}{"api-editors", "api-admins"}, security.RoleAdmin},
+		{[]interface{}{"others"}, security.RoleReader},
+		{nil, security.RoleReader},
+	}
+	for _, kid := range []string{"rsa", "ec"} {
+		for _, r := range roles {
+			claims, err := v.Verify(context.Background(), p.sign(t, kid, p.claims(r.groups...)))
+			if err != nil {
+				t.Fatalf("%s %v: %s", kid, r.groups, err)
+			}
+			if claims["role"] != r.role || claims["name"] != "jdoe" {
+				t.Errorf("%s %v: expected jdoe with the role %s, got %v %v", kid, r.groups, r.role, claims["name"],
+					claims["role"])
+			}
+		}
+	}
+
+	// aud can be a list, the username falls back to sub
+	claims := p.claims("api-admins")
+	claims["aud"] = []interface{}{"other", "rest-api"}
+	delete(claims, "preferred_username")
+	got, err := v.Verify(context.Background(), p.sign(t, "rsa", claims))
+	if err != nil || got["name"] != "1234" {
+		t.Errorf("expected the user 1234, got %v %v", got["name"], err)
+	}
+
+	// exp in the leeway
+	claims = p.claims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	if _, err = v.Verify(context.Background(), p.sign(t, "rsa", claims)); err != nil {
+		t.Errorf("exp in the leeway must be accepted: %s", err)
+	}
+}
+
+func TestOIDCWrongTokens(t *testing.T) {
+	p := newIdP(t)
+	p.addKey(t, "rsa", security.RS256)
+	v := newVerifier(t, p, "")
+
+	wrong := map[string]func(jwt.MapClaims){
+		"wrong iss":   func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" },
+		"wrong aud":   func(c jwt.MapClaims) { c["aud"] = []interface{}{"other"} },
+		"no aud":      func(c jwt.MapClaims) { delete(c, "aud") },
+		"expired":     func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no exp":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"future nbf":  func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() },
+		"no role":     func(c jwt.MapClaims) { c["groups"] = []interface{}{"others"} },
+		"no username": func(c jwt.MapClaims) { delete(c, "preferred_username"); delete(c, "sub") },
+	}
+	for name, change := range wrong {
+		claims := p.claims("api-editors")
+		change(claims)
+		if _, err := v.Verify(context.Background(), p.sign(t, "rsa", claims)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// a token can't choose the algorithm: HS256 with the kid of the RSA key
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, p.claims("api-admins"))
+	hs.Header["kid"] = "rsa"
+	signed, _ := hs.SignedString([]byte("secret"))
+	if _, err := v.Verify(context.Background(), signed); err == nil {
+		t.Errorf("a HS256 token must be rejected")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	p := newIdP(t)
+	p.addKey(t, "first", security.RS256)
+	v, err := security.NewOIDCVerifier(security.OIDCConfig{
+		Issuer: p.URL, Audience: "rest-api", DefaultRole: security.RoleReader, JWKSMinRefresh: time.Millisecond,
+	}, p.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.Verify(context.Background(), p.sign(t, "first", p.claims())); err != nil {
+		t.Fatal(err)
+	}
+	// the keys are cached
+	v.Verify(context.Background(), p.sign(t, "first", p.claims()))
+	if p.calls() != 1 {
+		t.Errorf("expected the keys fetched once, got %d", p.calls())
+	}
+
+	// the provider rotates the keys: the unknown kid makes the verifier fetch them again
+	p.addKey(t, "second", security.RS256)
+	if _, err = v.Verify(context.Background(), p.sign(t, "second", p.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if p.calls() != 2 {
+		t.Errorf("expected the keys fetched twice, got %d", p.calls())
+	}
+}
+
+func TestOIDCRefreshLimit(t *testing.T) {
+	p := newIdP(t)
+	p.addKey(t, "rsa", security.RS256)
+	v := newVerifier(t, p, security.RoleReader)
+	other := newIdP(t)
+	other.addKey(t, "random", security.RS256)
+
+	// tokens with unknown kids fetch the keys at most once every JWKSMinRefresh
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(context.Background(), other.sign(t, "random", p.claims())); err == nil {
+			t.Fatalf("a token with an unknown kid must be rejected")
+		}
+	}
+	if p.calls() != 1 {
+		t.Errorf("expected the keys fetched once, got %d", p.calls())
+	}
+}
+
+func TestOIDCNestedRoleClaim(t *testing.T) {
+	p := newIdP(t)
+	p.addKey(t, "rsa", security.RS256)
+	v, err := security.NewOIDCVerifier(security.OIDCConfig{
+		Issuer: p.URL, Audience: "rest-api", RoleClaim: "realm_access.roles",
+		Roles: map[string]string{"api-editors": security.RoleEditor},
+	}, p.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := p.claims()
+	claims["realm_access"] = map[string]interface{}{"roles": []interface{}{"api-editors"}}
+	got, err := v.Verify(context.Background(), p.sign(t, "rsa", claims))
+	if err != nil || got["role"] != security.RoleEditor {
+		t.Errorf("expected the role editor, got %v %v", got["role"], err)
+	}
+
+	// the configuration can map only to the local roles
+	_, err = security.NewOIDCVerifier(security.OIDCConfig{
+		Issuer: p.URL, Audience: "rest-api", Roles: map[string]string{"api-editors": "superuser"},
+	}, nil)
+	if err == nil {
+		t.Errorf("expected an error for an unknown role")
+	}
+}
@@ -0,0 +1,86 @@
+package security
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/security"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	for _, algorithm := range []string{security.BcryptAlgorithm, security.Argon2idAlgorithm} {
+		h, err := security.NewPasswordHasher(algorithm, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, err := h.Hash("my-pwd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, _ := h.Hash("my-pwd")
+		if first == second {
+			t.Errorf("%s: two hashes of the same password must differ (random salt)", algorithm)
+		}
+		if !strings.HasPrefix(first, "$") || !h.Owns(first) {
+			t.Errorf("%s: %s is not a PHC string of the algorithm", algorithm, first)
+		}
+		if ok, err := h.Verify("my-pwd", first); !ok || err != nil {
+			t.Errorf("%s: expected the password verified, got %v (err: %v)", algorithm, ok, err)
+		}
+		if ok, _ := h.Verify("wrong-pwd", first); ok {
+			t.Errorf("%s: a wrong password has been verified", algorithm)
+		}
+		if h.NeedsRehash(first) {
+			t.Errorf("%s: a hash created with the current parameters doesn't need a rehash", algorithm)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	if _, err := security.NewPasswordHasher("md5", 0); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+	if _, err := security.NewPasswordHasher(security.BcryptAlgorithm, 99); err == nil {
+		t.Error("expected an error for a wrong bcrypt cost")
+	}
+	if h, err := security.NewPasswordHasher("", 0); err != nil || !h.Owns("$argon2id$") {
+		t.Errorf("expected argon2id as default algorithm (err: %v)", err)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	argon, _ := security.NewPasswordHasher(security.Argon2idAlgorithm, 0)
+	bcrypt4, _ := security.NewPasswordHasher(security.BcryptAlgorithm, 4)
+	bcrypt5, _ := security.NewPasswordHasher(security.BcryptAlgorithm, 5)
+	argonHash, _ := argon.Hash("my-pwd")
+	bcryptHash, _ := bcrypt4.Hash("my-pwd")
+	legacyHash := fmt.Sprintf("%x", sha256.Sum256([]byte("my-pwd")))
+
+	tests := []struct {
+		name      string
+		preferred security.PasswordHasher
+		password  string
+		hash      string
+		ok        bool
+		rehash    bool
+	}{
+		{"same algorithm", argon, "my-pwd", argonHash, true, false},
+		{"wrong password", argon, "wrong", argonHash, false, false},
+		{"other algorithm", argon, "my-pwd", bcryptHash, true, true},
+		{"other parameters", bcrypt5, "my-pwd", bcryptHash, true, true},
+		{"legacy sha256", argon, "my-pwd", legacyHash, true, true},
+		{"legacy sha256 wrong password", argon, "wrong", legacyHash, false, false},
+	}
+	for _, test := range tests {
+		ok, rehash, err := security.VerifyPassword(test.preferred, test.password, test.hash)
+		if err != nil || ok != test.ok || rehash != test.rehash {
+			t.Errorf("%s: expected ok=%v rehash=%v, got ok=%v rehash=%v (err: %v)", test.name, test.ok,
+				test.rehash, ok, rehash, err)
+		}
+	}
+
+	if _, _, err := security.VerifyPassword(argon, "my-pwd", "plain-text"); err != security.ErrUnknownHash {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+}
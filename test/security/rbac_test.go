@@ -0,0 +1,31 @@
+package security
+
+import (
+	"github.com/mas2020-golang/rest-api/security"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role, permission string
+		expected         bool
+	}{
+		{security.RoleAdmin, security.PermUsersManage, true},
+		{security.RoleAdmin, security.PermProductsReadDeleted, true},
+		{security.RoleEditor, security.PermProductsWrite, true},
+		{security.RoleEditor, security.PermUsersManage, false},
+		{security.RoleEditor, security.PermProductsDelete, false},
+		{security.RoleAdmin, security.PermProductsDelete, true},
+		{security.RoleReader, security.PermProductsRead, true},
+		{security.RoleReader, security.PermProductsWrite, false},
+		{"unknown", security.PermProductsRead, false},
+	}
+	for _, test := range tests {
+		if got := security.HasPermission(test.role, test.permission); got != test.expected {
+			t.Errorf("%s %s: expected %v, got %v", test.role, test.permission, test.expected, got)
+		}
+	}
+	if security.RoleOf("") != security.RoleReader || security.RoleOf("admin") != security.RoleAdmin {
+		t.Error("RoleOf must return reader for the users without user_type")
+	}
+}
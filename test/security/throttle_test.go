@@ -0,0 +1,57 @@
+package security
+
+import (
+	"github.com/mas2020-golang/rest-api/security"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := security.LoginPolicy{MaxFailures: 5, Backoff: time.Second, Lockout: time.Minute}
+	expected := []struct {
+		delay   time.Duration
+		lockout bool
+	}{
+		{0, false}, {time.Second, false}, {2 * time.Second, false}, {4 * time.Second, false}, {8 * time.Second, false},
+		{time.Minute, true}, {time.Minute, true},
+	}
+	for failures, e := range expected {
+		if delay, lockout := p.Delay(failures); delay != e.delay || lockout != e.lockout {
+			t.Errorf("%d failures: expected %s %v, got %s %v", failures, e.delay, e.lockout, delay, lockout)
+		}
+	}
+	// the backoff never exceeds the lockout
+	p = security.LoginPolicy{MaxFailures: 100, Backoff: time.Second, Lockout: time.Minute}
+	if delay, _ := p.Delay(99); delay != time.Minute {
+		t.Errorf("expected the backoff capped to the lockout, got %s", delay)
+	}
+
+	p = security.LoginPolicy{Backoff: time.Hour}.WithDefaults(security.DefaultUserLoginPolicy)
+	if p != security.DefaultUserLoginPolicy {
+		t.Errorf("expected the default policy, got %+v", p)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := security.NewThrottle(security.LoginPolicy{MaxFailures: 3, Backoff: time.Hour, Lockout: 2 * time.Hour})
+	if th.RetryAfter("a") != 0 {
+		t.Errorf("a new key must not wait")
+	}
+	if wait := th.Fail("a"); wait != time.Hour {
+		t.Errorf("expected the backoff of 1h, got %s", wait)
+	}
+	if wait := th.RetryAfter("a"); wait <= 59*time.Minute || wait > time.Hour {
+		t.Errorf("expected to wait about 1h, got %s", wait)
+	}
+	if th.RetryAfter("b") != 0 {
+		t.Errorf("the keys are independent")
+	}
+	th.Fail("a")
+	if wait := th.Fail("a"); wait != 2*time.Hour {
+		t.Errorf("expected the lockout after 3 failures, got %s", wait)
+	}
+	th.Reset("a")
+	if th.RetryAfter("a") != 0 {
+		t.Errorf("a reset key must not wait")
+	}
+}
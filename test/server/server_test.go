@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/server"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv("APP_CONFIG")) == 0 {
+		os.Setenv("APP_CONFIG", "../../config/server.yml")
+	}
+	// the admin listener of the metrics on a free port
+	os.Setenv("APP_METRICS_LISTEN", "127.0.0.1:0")
+	os.Exit(m.Run())
+}
+
+// newApp returns an application with the in memory repositories and a closer recording its call
+func newApp(closed *[]string) *server.App {
+	a := &server.App{}
+	a.InitializeWithRepository(models.NewMemProducts(), models.NewMemUsers(), models.NewMemTokens(),
+		models.NewMemApiKeys())
+	a.Lifecycle.Register("first", func(context.Context) error {
+		*closed = append(*closed, "first")
+		return nil
+	})
+	a.Lifecycle.Register("second", func(context.Context) error {
+		*closed = append(*closed, "second")
+		return nil
+	})
+	return a
+}
+
+func TestServeDrains(t *testing.T) {
+	var closed []string
+	a := newApp(&closed)
+	// a request still running when the shutdown begins
+	started := make(chan struct{})
+	a.Router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- a.Serve(ctx, ln) }()
+
+	url := "http://" + ln.Addr().String()
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ready, got %d", resp.StatusCode)
+	}
+
+	slow := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			t.Errorf("the request in flight must complete: %s", err)
+		}
+		slow <- resp
+	}()
+	<-started
+	cancel()
+
+	if resp := <-slow; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the request in flight served, got %v", resp)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected a clean stop, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+	if strings.Join(closed, ",") != "second,first" {
+		t.Errorf("expected the closers in reverse order, got %v", closed)
+	}
+	if !a.Health.ShuttingDown() {
+		t.Errorf("expected the application unready")
+	}
+	// the listener is closed
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Errorf("expected the connection refused after the shutdown")
+	}
+}
+
+func TestServeFails(t *testing.T) {
+	var closed []string
+	a := newApp(&closed)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server can't accept the connections
+	ln.Close()
+
+	err = a.Serve(context.Background(), ln)
+	if err == nil || !strings.Contains(err.Error(), "http server") {
+		t.Errorf("expected the error of the server, got %v", err)
+	}
+	if len(closed) != 2 {
+		t.Errorf("expected the closers called also after a failure, got %v", closed)
+	}
+}
+
+func TestRunListenError(t *testing.T) {
+	var closed []string
+	a := newApp(&closed)
+	if err := a.Run("127.0.0.1:-1"); err == nil {
+		t.Errorf("expected an error for a wrong address")
+	}
+}
@@ -0,0 +1,308 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record sets a global TracerProvider keeping the ended spans in the returned recorder until the end of the test
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// attr returns the value of the attribute of the span, nil if the span doesn't have it
+func attr(span sdktrace.ReadOnlySpan, key string) interface{} {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware("test"))
+	router.HandleFunc("/items/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "child")
+		tracing.RecordError(span, errors.New("failed"))
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	router.NotFoundHandler = tracing.Middleware("test")(http.NotFoundHandler())
+
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	child, server, notFound := spans[0], spans[1], spans[2]
+	if server.Name() != "GET /items/{id:[0-9]+}" || server.SpanKind() != trace.SpanKindServer ||
+		server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent().SpanID().String() != "00f067aa0ba902b7" || server.Status().Code != codes.Error {
+		t.Errorf("the server span must continue the remote trace, got %s %v %v", server.Name(),
+			server.SpanContext(), server.Status())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() || child.Status().Code != codes.Error ||
+		child.Status().Description != "failed" {
+		t.Errorf("the child must have the server span as parent and the error, got %v %v", child.Parent(),
+			child.Status())
+	}
+	// a 404 is an error of the client, not of the server
+	if notFound.Name() != "GET" || notFound.Status().Code != codes.Unset {
+		t.Errorf("unexpected span of the path not found %s %v", notFound.Name(), notFound.Status())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	recorder := record(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "parent")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := (&http.Client{Transport: tracing.Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	client := spans[0]
+	if client.SpanKind() != trace.SpanKindClient || client.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expected a client span child of the parent, got %s %v", client.Name(), client.Parent())
+	}
+	// the server continues the trace from the client span
+	sc := client.SpanContext()
+	if !strings.Contains(traceparent, sc.TraceID().String()+"-"+sc.SpanID().String()) {
+		t.Errorf("unexpected traceparent '%s'", traceparent)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	var buf bytes.Buffer
+	ratio := 0.0
+	cfg := tracing.Config{Exporter: tracing.ExporterStdout, ServiceName: "test", SampleRatio: &ratio}
+	provider, err := tracing.Setup(cfg, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new traces are not sampled, the caller decides for its traces
+	_, span := tracing.Start(context.Background(), "dropped")
+	span.End()
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	_, span = tracing.Start(ctx, "sampled")
+	span.End()
+	provider.Shutdown(context.Background())
+
+	var s struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("expected only the sampled span as JSON: %s (%s)", err, buf.String())
+	}
+	service := false
+	for _, kv := range s.Resource {
+		service = service || kv.Key == "service.name" && kv.Value.Value == "test"
+	}
+	if s.Name != "sampled" || s.SpanContext.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || !service {
+		t.Errorf("unexpected span %s", buf.String())
+	}
+
+	ratio = 2
+	for _, wrong := range []tracing.Config{{Exporter: "jaeger"}, {SampleRatio: &ratio}} {
+		if _, err := tracing.Setup(wrong, io.Discard); err == nil {
+			t.Errorf("expected an error for %+v", wrong)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	requests := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer otlp" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests <- body
+	}))
+	defer collector.Close()
+
+	cfg := tracing.Config{Exporter: tracing.ExporterOTLP, ServiceName: "test-service"}
+	cfg.OTLP.Endpoint = collector.URL
+	cfg.OTLP.Headers = map[string]string{"Authorization": "Bearer otlp"}
+	cfg.OTLP.Interval = time.Hour
+	provider, err := tracing.Setup(cfg, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracing.Start(context.Background(), "exported-span")
+	span.End()
+	// the spans are sent at the shutdown
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the body is protobuf, the strings are in it as they are
+	body := string(<-requests)
+	for _, part := range []string{"test-service", "exported-span"} {
+		if !strings.Contains(body, part) {
+			t.Errorf("expected %s in the request", part)
+		}
+	}
+}
+
+// fakeDB answers the statements without a database, Query and Exec last delay
+type fakeDB struct {
+	delay time.Duration
+	err   error
+}
+
+func (d *fakeDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	time.Sleep(d.delay)
+	return nil, d.err
+}
+
+func (d *fakeDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	time.Sleep(d.delay)
+	return nil, d.err
+}
+
+func (d *fakeDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return fakeRow{d.err}
+}
+
+func (d *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	return &fakeTx{db: d}, nil
+}
+
+// fakeRow returns err when scanned
+type fakeRow struct {
+	err error
+}
+
+func (r fakeRow) Scan(...interface{}) error {
+	return r.err
+}
+
+// fakeTx is a transaction of fakeDB
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (t *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return t.db.Exec(ctx, sql, args...)
+}
+
+func (t *fakeTx) CopyFrom(context.Context, pgx.Identifier, []string, pgx.CopyFromSource) (int64, error) {
+	return 2, nil
+}
+
+func TestDB(t *testing.T) {
+	recorder := record(t)
+	fake := &fakeDB{delay: 10 * time.Millisecond}
+	db := tracing.NewDB(fake)
+
+	// the statements outside of a trace are ignored
+	db.Exec(context.Background(), "DELETE FROM products")
+	ctx, parent := tracing.Start(context.Background(), "request")
+
+	// the span of the query ends when it returns, not when the rows are read
+	db.Query(ctx, "select * from products")
+	if spans := recorder.Ended(); len(spans) != 1 {
+		t.Fatalf("expected the span of the query ended, got %d spans", len(spans))
+	}
+	// the caller reads the rows
+	time.Sleep(50 * time.Millisecond)
+
+	// the span of QueryRow ends with the scan, no rows is not an error
+	fake.err = pgx.ErrNoRows
+	row := db.QueryRow(ctx, "SELECT name FROM products WHERE id = $1", 1)
+	if spans := recorder.Ended(); len(spans) != 1 {
+		t.Fatalf("the span of QueryRow must end with the scan, got %d spans", len(spans))
+	}
+	row.Scan()
+	fake.err = errors.New("boom")
+	db.QueryRow(ctx, "SELECT name FROM products WHERE id = $1", 1).Scan()
+
+	// the statements of the transactions
+	fake.err = nil
+	tx, _ := db.Begin(ctx)
+	tx.Exec(ctx, "UPDATE products SET price = 1")
+	tx.CopyFrom(ctx, pgx.Identifier{"products"}, []string{"name"}, pgx.CopyFromRows(nil))
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 6 {
+		t.Fatalf("expected 6 spans, got %d", len(spans))
+	}
+	query := spans[0]
+	if query.Name() != "SELECT" || query.SpanKind() != trace.SpanKindClient ||
+		query.Parent().SpanID() != parent.SpanContext().SpanID() || attr(query, "db.system") != "postgresql" ||
+		attr(query, "db.statement") != "select * from products" || attr(query, "db.operation") != "SELECT" {
+		t.Errorf("unexpected query span %s %v", query.Name(), query.Attributes())
+	}
+	if d := query.EndTime().Sub(query.StartTime()); d < 10*time.Millisecond || d > 40*time.Millisecond {
+		t.Errorf("expected the span to last the query, got %s", d)
+	}
+	if noRows, failed := spans[1], spans[2]; noRows.Status().Code != codes.Unset ||
+		failed.Status().Code != codes.Error || failed.Status().Description != "boom" {
+		t.Errorf("unexpected status of QueryRow %v %v", noRows.Status(), failed.Status())
+	}
+	if update, copy := spans[3], spans[4]; update.Name() != "UPDATE" || copy.Name() != "COPY" ||
+		attr(copy, "db.sql.table") != `"products"` {
+		t.Errorf("unexpected spans of the transaction %s %s %v", update.Name(), copy.Name(), copy.Attributes())
+	}
+	if spans[5].Name() != "request" {
+		t.Errorf("expected the request span last, got %s", spans[5].Name())
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"github.com/mas2020-golang/rest-api/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest calls a handler answering 200 through the CORS middleware
+func corsRequest(cfg utils.CORSConfig, method, origin string, preflight bool) *httptest.ResponseRecorder {
+	handler := utils.CORSMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(method, "/products", nil)
+	if len(origin) > 0 {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORS(t *testing.T) {
+	cfg := utils.Defaults().HTTP.CORS
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+
+	// preflight of an allowed origin
+	rr := corsRequest(cfg, http.MethodOptions, "https://app.example.com", true)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Methods") != "GET, POST, PUT, PATCH, DELETE" ||
+		rr.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("unexpected preflight response %d %v", rr.Code, rr.Header())
+	}
+
+	// request of an allowed origin
+	rr = corsRequest(cfg, http.MethodGet, "https://app.example.com", false)
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+
+	// other origins and same origin requests have no CORS headers
+	for _, origin := range []string{"https://evil.example.com", ""} {
+		rr = corsRequest(cfg, http.MethodGet, origin, false)
+		if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("origin '%s': unexpected response %d %v", origin, rr.Code, rr.Header())
+		}
+	}
+
+	// any origin
+	cfg.AllowedOrigins = []string{"*"}
+	rr = corsRequest(cfg, http.MethodGet, "https://other.example.com", false)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected *, got %v", rr.Header())
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rec := utils.NewStatusRecorder(rr)
+	if rec.Status() != http.StatusOK {
+		t.Errorf("expected 200 before any write, got %d", rec.Status())
+	}
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusInternalServerError)
+	rec.Write([]byte("hello"))
+	rec.Flush()
+	if rec.Status() != http.StatusCreated || rec.Bytes() != 5 || rr.Code != http.StatusCreated || !rr.Flushed {
+		t.Errorf("unexpected status %d and size %d", rec.Status(), rec.Bytes())
+	}
+	// http.ResponseController reaches the deadlines of the connection through Unwrap
+	if rec.Unwrap() != rr {
+		t.Errorf("the recorder doesn't unwrap to the underlying writer")
+	}
+}
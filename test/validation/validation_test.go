@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"github.com/mas2020-golang/rest-api/models"
+	"github.com/mas2020-golang/rest-api/validation"
+	"gopkg.in/go-playground/validator.v9"
+	"strings"
+	"testing"
+)
+
+// messages validates s and returns the messages of the fields not valid by field name
+func messages(t *testing.T, s interface{}) map[string]string {
+	err := validation.Struct(s)
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		t.Fatalf("unexpected error %s", err)
+	}
+	m := map[string]string{}
+	for _, fe := range errs {
+		m[fe.Field()] = validation.Message(fe)
+	}
+	return m
+}
+
+func TestMessages(t *testing.T) {
+	p := &models.Product{Name: strings.Repeat("a", 101), Price: -1, SKU: "abc"}
+	got := messages(t, p)
+	expected := map[string]string{
+		"name":  "name must be at most 100 characters long",
+		"price": "price must be greater than 0",
+		"sku":   "sku must be three groups of lowercase letters separated by '-' (e.g. abc-def-ghi)",
+	}
+	if len(got) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	for field, message := range expected {
+		if got[field] != message {
+			t.Errorf("%s: expected '%s', got '%s'", field, message, got[field])
+		}
+	}
+
+	u := &models.User{Email: "wrong", UserType: "root"}
+	got = messages(t, u)
+	if got["username"] != "username is a required field" || got["email"] != "email must be a valid email address" ||
+		!strings.HasPrefix(got["user-type"], "user-type must be one of") {
+		t.Errorf("unexpected messages %v", got)
+	}
+}
+
+func TestSKU(t *testing.T) {
+	tests := []struct {
+		sku   string
+		valid bool
+	}{
+		{"abc-def-ghi", true},
+		{"cof-esp-one", true},
+		// only the whole value must match
+		{"xabc-def-ghi1", false},
+		{"ABC-def-ghi", false},
+		{"abc-def-ghi-jkl", false},
+		{"abc def-ghi-jkl", false},
+		{"abc-def", false},
+		{strings.Repeat("a", 98) + "-b-c", false},
+	}
+	for _, test := range tests {
+		p := &models.Product{Name: "test", Price: 1, SKU: test.sku}
+		if err := p.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.sku, test.valid, err)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	type item struct {
+		Code  string `json:"code" validate:"sku"`
+		Label string `json:"label" validate:"omitempty,even_length"`
+	}
+	err := validation.Register(validation.Rule{
+		Tag:     "even_length",
+		Func:    func(fl validator.FieldLevel) bool { return len(fl.Field().String())%2 == 0 },
+		Message: "{0} must have an even length",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(t, &item{Code: "abc-def-ghi", Label: "ab"}); got != nil {
+		t.Errorf("unexpected errors %v", got)
+	}
+	got := messages(t, &item{Code: "ABC", Label: "abc"})
+	if got["code"] != "code must be three groups of lowercase letters separated by '-' (e.g. abc-def-ghi)" ||
+		got["label"] != "label must have an even length" {
+		t.Errorf("unexpected messages %v", got)
+	}
+}
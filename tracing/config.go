@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"io"
+	"strings"
+	"time"
+)
+
+// exporters of the configuration
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config is the tracing section of the configuration file
+type Config struct {
+	// Exporter of the spans: none (default), stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// ServiceName is the service.name of the spans (default rest-api)
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the ratio of the new traces that are exported (default 1), the traces started by the callers
+	// follow their sampled flag
+	SampleRatio *float64 `yaml:"sample_ratio"`
+	OTLP        struct {
+		// Endpoint of the collector (default http://localhost:4318), the spans are posted to <Endpoint>/v1/traces
+		Endpoint string `yaml:"endpoint"`
+		// Headers are added to the requests, e.g. for the authentication
+		Headers map[string]string `yaml:"headers"`
+		// Timeout of a request (default 10s)
+		Timeout time.Duration `yaml:"timeout"`
+		// Interval between two batches (default 5s)
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"otlp"`
+}
+
+// Service returns the service.name of the spans
+func (c Config) Service() string {
+	if len(c.ServiceName) == 0 {
+		return "rest-api"
+	}
+	return c.ServiceName
+}
+
+// Setup creates the TracerProvider of the configuration and sets it, with the W3C Trace Context propagator, as the
+// global one. stdout is where the stdout exporter writes. The provider must be shut down to send the pending spans.
+func Setup(cfg Config, stdout io.Writer) (*sdktrace.TracerProvider, error) {
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("the sample ratio must be between 0 and 1, got %v", ratio)
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.Service()))),
+	}
+	switch cfg.Exporter {
+	case ExporterNone, "":
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case ExporterOTLP:
+		endpoint, timeout, interval := cfg.OTLP.Endpoint, cfg.OTLP.Timeout, cfg.OTLP.Interval
+		if len(endpoint) == 0 {
+			endpoint = "http://localhost:4318"
+		}
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		exporter, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"),
+			otlptracehttp.WithHeaders(cfg.OTLP.Headers), otlptracehttp.WithTimeout(timeout))
+		if err != nil {
+			return nil, fmt.Errorf("wrong OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(interval)))
+	default:
+		return nil, fmt.Errorf("unknown exporter '%s', it must be %s, %s or %s", cfg.Exporter, ExporterNone,
+			ExporterStdout, ExporterOTLP)
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider, nil
+}
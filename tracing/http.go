@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"net/http"
+)
+
+// Middleware returns the middleware starting a server span for every request with otelmux, named with the method and
+// the route template of mux (only the method for the paths not found). The span is a child of the span of the
+// traceparent header if the request has one, it is stored into the request context. The TracerProvider and the
+// propagator are the global ones when Middleware is called.
+func Middleware(service string) mux.MiddlewareFunc {
+	return otelmux.Middleware(service, otelmux.WithSpanNameFormatter(spanName))
+}
+
+// spanName returns the name of the server span of a request
+func spanName(route string, r *http.Request) string {
+	if mux.CurrentRoute(r) == nil {
+		return r.Method
+	}
+	return r.Method + " " + route
+}
+
+// Transport returns the round tripper starting a client span for every request sent through base
+// (http.DefaultTransport if nil) with otelhttp, the traceparent header is added so the server continues the trace
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// DB is the part of a pool of connections traced by NewDB, *pgxpool.Pool implements it
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// NewDB returns db creating a client span for every statement, also of its transactions, as child of the span of
+// the statement context. The statements outside of a trace are not traced.
+func NewDB(db DB) DB {
+	return &tracedDB{db}
+}
+
+// tracedDB traces the statements of a DB
+type tracedDB struct {
+	db DB
+}
+
+// Exec implements DB, the span lasts the execution of the statement
+func (d *tracedDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, span := startStatement(ctx, sql)
+	defer span.End()
+	tag, err := d.db.Exec(ctx, sql, args...)
+	RecordError(span, err)
+	return tag, err
+}
+
+// Query implements DB, the span ends when the query returns: the time spent by the caller reading the rows is not
+// part of it
+func (d *tracedDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, span := startStatement(ctx, sql)
+	defer span.End()
+	rows, err := d.db.Query(ctx, sql, args...)
+	RecordError(span, err)
+	return rows, err
+}
+
+// QueryRow implements DB, the span ends when the row is scanned: pgx reports the errors of the query only then
+func (d *tracedDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, span := startStatement(ctx, sql)
+	return &tracedRow{d.db.QueryRow(ctx, sql, args...), span}
+}
+
+// Begin implements DB, the statements of the transaction are traced
+func (d *tracedDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{tx, &tracedDB{tx}}, nil
+}
+
+// tracedRow ends the span of a QueryRow when it is scanned
+type tracedRow struct {
+	row  pgx.Row
+	span trace.Span
+}
+
+// Scan implements pgx.Row, no rows is not an error of the statement
+func (r *tracedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err != pgx.ErrNoRows {
+		RecordError(r.span, err)
+	}
+	r.span.End()
+	return err
+}
+
+// tracedTx is a transaction whose statements are traced
+type tracedTx struct {
+	pgx.Tx
+	db *tracedDB
+}
+
+// Exec implements pgx.Tx
+func (t *tracedTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return t.db.Exec(ctx, sql, args...)
+}
+
+// Query implements pgx.Tx
+func (t *tracedTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return t.db.Query(ctx, sql, args...)
+}
+
+// QueryRow implements pgx.Tx
+func (t *tracedTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return t.db.QueryRow(ctx, sql, args...)
+}
+
+// CopyFrom implements pgx.Tx, the span lasts the copy of all the rows
+func (t *tracedTx) CopyFrom(ctx context.Context, table pgx.Identifier, columns []string,
+	src pgx.CopyFromSource) (int64, error) {
+	ctx, span := start(ctx, "COPY", attribute.String("db.sql.table", table.Sanitize()))
+	defer span.End()
+	n, err := t.Tx.CopyFrom(ctx, table, columns, src)
+	RecordError(span, err)
+	return n, err
+}
+
+// startStatement starts the span of a SQL statement, named with its command (e.g. SELECT)
+func startStatement(ctx context.Context, sql string) (context.Context, trace.Span) {
+	operation := "SQL"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	return start(ctx, operation, attribute.String("db.statement", sql))
+}
+
+// start starts the client span of a database operation, the span of ctx (not recording) is returned if ctx is not
+// part of a trace
+func start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	attrs = append(attrs, attribute.String("db.system", "postgresql"), attribute.String("db.operation", operation))
+	return Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,32 @@
+/*
+Package tracing traces the requests with OpenTelemetry.
+
+Setup installs the TracerProvider of the OpenTelemetry SDK, exporting the spans to stdout or to an OTLP/HTTP
+collector, and the W3C Trace Context propagator. Middleware starts a server span for every request, continuing the
+trace of the caller if the request has a traceparent header. The handlers start child spans with Start, the
+statements of the repositories are traced by the database returned by NewDB.
+*/
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the tracer of the application
+const instrumentation = "github.com/mas2020-golang/rest-api"
+
+// Start starts a span, child of the span in ctx, with the global TracerProvider and returns a copy of ctx with it
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// RecordError records err on the span and sets its status to error, nothing is done if err is nil
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,361 @@
+package utils
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/mas2020-golang/rest-api/security"
+	"github.com/mas2020-golang/rest-api/tracing"
+	"gopkg.in/yaml.v2"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConfigPath is the configuration file read if neither the --config flag nor APP_CONFIG is set
+const DefaultConfigPath = "config/server.yml"
+
+// envPrefix is the prefix of the environment variables overriding the configuration, e.g. APP_HTTP_ADDRESS
+const envPrefix = "APP_"
+
+// envConfig is the environment variable with the path of the configuration file
+const envConfig = "APP_CONFIG"
+
+// envAliases are the old names of some environment variables, still accepted
+var envAliases = map[string]string{
+	"APP_DB_HOST":     "database.host",
+	"APP_DB_USERNAME": "database.user",
+	"APP_DB_PASSWORD": "database.password",
+	"APP_DB_NAME":     "database.name",
+}
+
+// Loader reads the configuration in layers, every layer overriding the previous ones: the defaults (see Defaults),
+// the YAML file, the APP_* environment variables and the command line flags. The unknown keys of the file, the
+// unknown APP_* variables and the values not valid are errors.
+type Loader struct {
+	path      string
+	overrides []override
+	environ   func() []string
+}
+
+// override is the value of a key set by a flag
+type override struct {
+	key   string
+	value string
+}
+
+// NewLoader returns a loader reading the file of APP_CONFIG (or config/server.yml) and the environment
+func NewLoader() *Loader {
+	return &Loader{environ: os.Environ}
+}
+
+// SetEnviron replaces the environment read by the loader, the variables are in the form key=value
+func (l *Loader) SetEnviron(environ []string) {
+	l.environ = func() []string { return environ }
+}
+
+// RegisterFlags adds to fs the --config flag and a flag for every key of the configuration, named as its path in
+// the file (e.g. --http.address, --database.max_conns). The lists are comma separated.
+func (l *Loader) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&l.path, "config", "", fmt.Sprintf("configuration file (default $%s or %s)", envConfig,
+		DefaultConfigPath))
+	defaults := Defaults()
+	for _, key := range keys(&defaults) {
+		key := key
+		fs.Func(key.path, fmt.Sprintf("overrides %s (env %s)", key.path, envName(key.path)),
+			func(value string) error {
+				// the value is checked now, so that the error names the flag
+				if err := set(reflect.New(key.value.Type()).Elem(), value); err != nil {
+					return err
+				}
+				l.overrides = append(l.overrides, override{key.path, value})
+				return nil
+			})
+	}
+}
+
+// Path returns the configuration file read by Load
+func (l *Loader) Path() string {
+	if len(l.path) > 0 {
+		return l.path
+	}
+	for _, kv := range l.environ() {
+		if strings.HasPrefix(kv, envConfig+"=") && len(kv) > len(envConfig)+1 {
+			return kv[len(envConfig)+1:]
+		}
+	}
+	return DefaultConfigPath
+}
+
+// Load returns the configuration, validated
+func (l *Loader) Load() (ServerT, error) {
+	cfg := Defaults()
+	if err := l.readFile(&cfg); err != nil {
+		return cfg, err
+	}
+	byPath := map[string]reflect.Value{}
+	for _, key := range keys(&cfg) {
+		byPath[key.path] = key.value
+	}
+	if err := l.applyEnv(byPath); err != nil {
+		return cfg, err
+	}
+	for _, o := range l.overrides {
+		if err := set(byPath[o.key], o.value); err != nil {
+			return cfg, fmt.Errorf("flag --%s: %s", o.key, err.Error())
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// unknownField matches the error of yaml for an unknown key, the type in the message is not useful to the users
+var unknownField = regexp.MustCompile(`field (\S+) not found in type .*`)
+
+// readFile decodes the YAML file into cfg, the keys not in the file keep their value
+func (l *Loader) readFile(cfg *ServerT) error {
+	path := l.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read the configuration file: %s", err.Error())
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// the unknown keys are errors, e.g. a typo would silently leave the default value
+	decoder.SetStrict(true)
+	if err = decoder.Decode(cfg); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		msg := unknownField.ReplaceAllString(strings.TrimPrefix(err.Error(), "yaml: "), "unknown key '$1'")
+		return fmt.Errorf("configuration file %s: %s", path, msg)
+	}
+	return nil
+}
+
+// applyEnv sets the keys of the APP_* environment variables
+func (l *Loader) applyEnv(byPath map[string]reflect.Value) error {
+	env := map[string]string{}
+	for _, kv := range l.environ() {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, envPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	// the aliases first, so that the current names win
+	sort.Slice(names, func(i, j int) bool {
+		_, aliasI := envAliases[names[i]]
+		_, aliasJ := envAliases[names[j]]
+		if aliasI != aliasJ {
+			return aliasI
+		}
+		return names[i] < names[j]
+	})
+
+	paths := map[string]string{}
+	for path := range byPath {
+		paths[envName(path)] = path
+	}
+	for _, name := range names {
+		if name == envConfig {
+			continue
+		}
+		path, ok := paths[name]
+		if !ok {
+			if path, ok = envAliases[name]; !ok {
+				return fmt.Errorf("unknown environment variable %s: the APP_* variables must be keys of the "+
+					"configuration, e.g. APP_HTTP_ADDRESS for http.address", name)
+			}
+		}
+		if err := set(byPath[path], env[name]); err != nil {
+			return fmt.Errorf("environment variable %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Validate checks the values of the configuration, the error lists every value not valid
+func (s ServerT) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	nonNegative := func(key string, d time.Duration) {
+		check(d >= 0, "%s must not be negative, got %s", key, d)
+	}
+
+	check(s.Logging.Level >= 0 && s.Logging.Level <= 6, "logging.level must be between 0 and 6, got %d",
+		s.Logging.Level)
+	check(oneOf(s.Logging.Format, "text", "json", "logfmt"), "logging.format must be text, json or logfmt, got '%s'",
+		s.Logging.Format)
+
+	check(len(s.HTTP.Address) > 0, "http.address is required")
+	nonNegative("http.read_timeout", s.HTTP.ReadTimeout)
+	nonNegative("http.write_timeout", s.HTTP.WriteTimeout)
+	nonNegative("http.idle_timeout", s.HTTP.IdleTimeout)
+	nonNegative("http.cors.max_age", s.HTTP.CORS.MaxAge)
+	check(!s.HTTP.CORS.AllowCredentials || !oneOf("*", s.HTTP.CORS.AllowedOrigins...),
+		"http.cors.allow_credentials can't be used with the * origin, list the allowed origins")
+
+	db := s.Database
+	if len(db.DSN) == 0 {
+		check(len(db.Host) > 0, "database.host is required if database.dsn is not set")
+		check(db.Port > 0 && db.Port < 65536, "database.port must be between 1 and 65535, got %d", db.Port)
+		check(oneOf(db.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			"database.sslmode must be disable, allow, prefer, require, verify-ca or verify-full, got '%s'",
+			db.SSLMode)
+	}
+	check(db.MaxConns > 0, "database.max_conns must be positive, got %d", db.MaxConns)
+	check(db.MinConns >= 0 && db.MinConns <= db.MaxConns,
+		"database.min_conns must be between 0 and database.max_conns, got %d", db.MinConns)
+	nonNegative("database.connect_timeout", db.ConnectTimeout)
+	nonNegative("database.max_conn_lifetime", db.MaxConnLifetime)
+	nonNegative("database.max_conn_idle_time", db.MaxConnIdleTime)
+
+	check(oneOf(s.Password.Algorithm, security.Argon2idAlgorithm, security.BcryptAlgorithm),
+		"password.algorithm must be %s or %s, got '%s'", security.Argon2idAlgorithm, security.BcryptAlgorithm,
+		s.Password.Algorithm)
+	if s.Password.Algorithm == security.BcryptAlgorithm {
+		check(s.Password.BcryptCost >= 4 && s.Password.BcryptCost <= 31,
+			"password.bcrypt_cost must be between 4 and 31, got %d", s.Password.BcryptCost)
+	}
+	check(s.Token.AccessTTL > 0, "token.access_ttl must be positive, got %s", s.Token.AccessTTL)
+	check(s.Token.RefreshTTL >= s.Token.AccessTTL, "token.refresh_ttl must not be shorter than token.access_ttl")
+
+	check(strings.HasPrefix(s.Metrics.Path, "/"), "metrics.path must start with /, got '%s'", s.Metrics.Path)
+	check(!s.Metrics.Enabled || len(s.Metrics.Listen) > 0 || len(s.Metrics.Token) > 0,
+		"metrics.token is mandatory when the metrics are served by the API listener (metrics.listen is empty)")
+	nonNegative("metrics.read_timeout", s.Metrics.ReadTimeout)
+	nonNegative("metrics.write_timeout", s.Metrics.WriteTimeout)
+	nonNegative("metrics.idle_timeout", s.Metrics.IdleTimeout)
+	nonNegative("shutdown.timeout", s.Shutdown.Timeout)
+	nonNegative("shutdown.delay", s.Shutdown.Delay)
+	check(oneOf(s.Tracing.Exporter, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP),
+		"tracing.exporter must be none, stdout or otlp, got '%s'", s.Tracing.Exporter)
+	if ratio := s.Tracing.SampleRatio; ratio != nil {
+		check(*ratio >= 0 && *ratio <= 1, "tracing.sample_ratio must be between 0 and 1, got %v", *ratio)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// oneOf reports whether value is one of values
+func oneOf(value string, values ...string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// key is a value of the configuration that can be set by an environment variable or a flag
+type key struct {
+	path  string
+	value reflect.Value
+}
+
+// durationType is the type of the durations, set with time.ParseDuration
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// keys returns the keys of cfg with a scalar value or a list of strings, sorted by path. The maps and the lists of
+// structs (e.g. token.keys) can be set only in the file.
+func keys(cfg *ServerT) []key {
+	var list []key
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.SplitN(v.Type().Field(i).Tag.Get("yaml"), ",", 2)[0]
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			path := prefix + name
+			field := v.Field(i)
+			switch {
+			case field.Kind() == reflect.Struct:
+				walk(field, path+".")
+			case settable(field.Type()):
+				list = append(list, key{path, field})
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	sort.Slice(list, func(i, j int) bool { return list[i].path < list[j].path })
+	return list
+}
+
+// settable reports whether the values of the type can be read from a string
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Ptr:
+		return settable(t.Elem()) && t.Elem().Kind() != reflect.Ptr
+	}
+	return false
+}
+
+// set sets v reading the string s
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration (e.g. 30s, 5m, 1h)", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean (true or false)", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := set(elem.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(elem)
+	default:
+		return fmt.Errorf("the type %s can't be set", v.Type())
+	}
+	return nil
+}
+
+// envName returns the environment variable of a key, e.g. APP_HTTP_READ_TIMEOUT for http.read_timeout
+func envName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
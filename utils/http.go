@@ -1,15 +1,148 @@
 package utils
 
 import (
-	"fmt"
+	"context"
+	"github.com/google/uuid"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-// ReturnError returns an error to the caller
-func ReturnError(w *http.ResponseWriter, message string, responseCode int)  {
-	(*w).WriteHeader(responseCode)
-	(*w).Header().Set("Content-Type", "application/json")
-	body := fmt.Sprintf(`{"error": "%s"}`, message)
-	(*w).Write([]byte(body))
+// RequestIDHeader is the header with the id of the request, it is also returned in the response
+const RequestIDHeader = "X-Request-ID"
+
+// contextKey is the type of the keys stored by this package into the request context
+type contextKey string
+
+const requestIDKey contextKey = "request-id"
+
+// validRequestID matches the ids accepted from the callers, anything else is replaced with a new id
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware gives an id to every request: the one in the X-Request-ID header if the caller (or a proxy)
+// sent a valid one, a new uuid otherwise. The id is returned in the X-Request-ID header and stored in the request
+// context, see RequestID.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// RequestID returns the id of the request stored by RequestIDMiddleware, empty if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// StatusRecorder wraps the writer of a response remembering its status code and size, for the middlewares
+// measuring the responses (access log, metrics)
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// NewStatusRecorder returns the recorder writing to w
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w}
+}
+
+// Status returns the status code of the response, 200 if the handler didn't write it
+func (s *StatusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
 }
 
+// Bytes returns the size of the body written
+func (s *StatusRecorder) Bytes() int {
+	return s.bytes
+}
+
+// WriteHeader records the status code
+func (s *StatusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body, the status is 200 if WriteHeader was not called
+func (s *StatusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Flush sends the buffered data to the client, if the underlying writer can do it
+func (s *StatusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, http.ResponseController uses it to reach e.g. SetWriteDeadline
+func (s *StatusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// CORSMiddleware adds the CORS headers to the responses to the allowed origins and answers the preflight requests
+// (OPTIONS with Access-Control-Request-Method) with 204. The requests from the other origins are served without
+// the headers, so the browsers don't give the responses to the scripts.
+func CORSMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	anyOrigin := false
+	origins := map[string]bool{}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(origin)] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			// the response depends on the origin, also when it is not allowed
+			w.Header().Add("Vary", "Origin")
+			if len(origin) == 0 || (!anyOrigin && !origins[strings.ToLower(origin)]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if anyOrigin && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if len(exposed) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Package validation is the validator shared by all the models.
+
+The rules are the ones of go-playground/validator plus the custom rules of the application (see Register and
+RegisterAlias), registered once and usable by any model in its validate tags. The errors use the JSON names of the
+fields and have an English message for every rule.
+*/
+package validation
+
+import (
+	"fmt"
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	entranslations "gopkg.in/go-playground/validator.v9/translations/en"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Rule is a custom validation rule. Message is the English message of the rule, {0} is replaced with the name of
+// the field and {1} with the parameter of the rule.
+type Rule struct {
+	Tag     string
+	Func    validator.Func
+	Message string
+}
+
+// Alias is a reusable combination of rules (e.g. a max length), the errors report the alias as rule
+type Alias struct {
+	Tag     string
+	Rules   string
+	Message string
+}
+
+// skuRegexp is the regular expression of the sku rule, compiled once
+var skuRegexp = regexp.MustCompile(`^[a-z]+-[a-z]+-[a-z]+$`)
+
+// rules are the custom rules of the application
+var rules = []Rule{
+	{
+		Tag:     "sku",
+		Func:    matchString(skuRegexp, 100),
+		Message: "{0} must be three groups of lowercase letters separated by '-' (e.g. abc-def-ghi)",
+	},
+}
+
+// aliases are the reusable combinations of rules of the application
+var aliases = []Alias{
+	{Tag: "short_text", Rules: "max=100", Message: "{0} must be at most 100 characters long"},
+	{Tag: "long_text", Rules: "max=500", Message: "{0} must be at most 500 characters long"},
+}
+
+// the shared validator and its translator, validator.Validate caches the structs and is safe for concurrent use
+var (
+	validate   *validator.Validate
+	translator ut.Translator
+)
+
+func init() {
+	validate = validator.New()
+	// the errors use the JSON names of the fields
+	validate.RegisterTagNameFunc(jsonName)
+
+	english := en.New()
+	translator, _ = ut.New(english, english).GetTranslator("en")
+	if err := entranslations.RegisterDefaultTranslations(validate, translator); err != nil {
+		panic(fmt.Sprintf("validation: %s", err.Error()))
+	}
+	for _, rule := range rules {
+		if err := Register(rule); err != nil {
+			panic(err.Error())
+		}
+	}
+	for _, alias := range aliases {
+		if err := RegisterAlias(alias); err != nil {
+			panic(err.Error())
+		}
+	}
+}
+
+// Register adds a custom rule to the shared validator. The rules must be registered before validating, e.g. in an
+// init function.
+func Register(rule Rule) error {
+	if err := validate.RegisterValidation(rule.Tag, rule.Func); err != nil {
+		return fmt.Errorf("validation: rule %s: %s", rule.Tag, err.Error())
+	}
+	return addMessage(rule.Tag, rule.Message)
+}
+
+// RegisterAlias adds a combination of rules to the shared validator. The aliases must be registered before
+// validating, e.g. in an init function.
+func RegisterAlias(alias Alias) error {
+	validate.RegisterAlias(alias.Tag, alias.Rules)
+	return addMessage(alias.Tag, alias.Message)
+}
+
+// Struct validates the struct with the rules of its validate tags, the error is a validator.ValidationErrors if a
+// field is not valid
+func Struct(s interface{}) error {
+	return validate.Struct(s)
+}
+
+// Message returns the English message of the error of a field, a generic one for a rule without a message
+func Message(fe validator.FieldError) string {
+	msg := fe.Translate(translator)
+	if err, ok := fe.(error); ok && msg == err.Error() {
+		return fmt.Sprintf("%s failed on the '%s' rule", fe.Field(), fe.Tag())
+	}
+	return msg
+}
+
+// addMessage registers the message of a rule
+func addMessage(tag, message string) error {
+	err := validate.RegisterTranslation(tag, translator, func(t ut.Translator) error {
+		return t.Add(tag, message, true)
+	}, func(t ut.Translator, fe validator.FieldError) string {
+		msg, err := t.T(fe.Tag(), fe.Field(), fe.Param())
+		if err != nil {
+			return fe.(error).Error()
+		}
+		return msg
+	})
+	if err != nil {
+		return fmt.Errorf("validation: message of the rule %s: %s", tag, err.Error())
+	}
+	return nil
+}
+
+// matchString returns a rule accepting the strings of at most max characters fully matching re
+func matchString(re *regexp.Regexp, max int) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		value := fl.Field().String()
+		return len(value) <= max && re.MatchString(value)
+	}
+}
+
+// jsonName returns the name of the field in its JSON representation, the Go name if it has no json tag
+func jsonName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if len(name) == 0 || name == "-" {
+		return field.Name
+	}
+	return name
+}